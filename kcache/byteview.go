@@ -1,8 +1,17 @@
 package kcache
 
-// A ByteView holds an immutable view of bytes.
+import "time"
+
+// A ByteView holds an immutable view of bytes,
+// along with the metadata the Retriever attached to them.
 type ByteView struct {
-	b []byte
+	b       []byte
+	etag    string
+	expire  time.Time // zero means the value never expires
+	noCache bool      // the value was not stored in any cache
+	tags    []string  // used for bulk invalidation, must not be modified
+	version uint64    // assigned by the owner on every write
+	stale   bool      // the value had expired and was served because a load was rejected
 }
 
 func cloneBytes(b []byte) []byte {
@@ -25,3 +34,45 @@ func (v ByteView) ByteSlice() []byte {
 func (v ByteView) String() string {
 	return string(v.b)
 }
+
+// ETag returns the version of the data given by the Retriever.
+func (v ByteView) ETag() string {
+	return v.etag
+}
+
+// Expire returns when the cached value expires.
+// The zero time means it never expires.
+func (v ByteView) Expire() time.Time {
+	return v.expire
+}
+
+// NoCache reports whether the Retriever asked not to cache the value.
+func (v ByteView) NoCache() bool {
+	return v.noCache
+}
+
+// Tags returns the tags attached to the value, the caller must not modify it.
+func (v ByteView) Tags() []string {
+	return v.tags
+}
+
+// Version returns the version assigned by the owner of the key.
+// It increases on every write, see Group.CompareAndSwap.
+func (v ByteView) Version() uint64 {
+	return v.version
+}
+
+// Stale reports whether the value had expired and was returned
+// in place of a load rejected by the circuit breaker or the load limits.
+func (v ByteView) Stale() bool {
+	return v.stale
+}
+
+// ttl returns the remaining lifetime of the value, 0 means it never expires.
+func (v ByteView) ttl() time.Duration {
+	if v.expire.IsZero() {
+		return 0
+	}
+	// 即将过期的值也至少保留1ms 以免被当作永不过期
+	return max(time.Until(v.expire), time.Millisecond)
+}
@@ -2,39 +2,490 @@ package kcache
 
 import (
 	"kcache/kcache/lru"
+	"kcache/kcache/slab"
+	"runtime"
+	"strconv"
 	"sync"
+	"time"
+	"unsafe"
 )
 
+// sweepInterval 后台清理过期缓存的周期
+const sweepInterval = time.Minute
+
+// EntryOverhead 是cache中每条记录除key与value数据外的估算开销(Byte)
+// 即 lru.EntryOverhead 加上装箱存入淘汰策略的ByteView
+const EntryOverhead = lru.EntryOverhead + int64(unsafe.Sizeof(ByteView{}))
+
+// Policy 根据缓存容量创建一个淘汰策略实例
+type Policy func(capacity int64) lru.Policy
+
+// LRUPolicy 最近最久未使用 默认策略
+func LRUPolicy() Policy {
+	return func(capacity int64) lru.Policy { return lru.New(capacity, nil) }
+}
+
+// LRUKPolicy 访问k次后才进入缓存队列的LRU-K 可抵御一次性扫描
+func LRUKPolicy(k int) Policy {
+	return func(capacity int64) lru.Policy { return lru.NewLRUK(k, capacity, nil) }
+}
+
+// LFUPolicy 最不经常使用 适合热点稳定的访问模式
+func LFUPolicy() Policy {
+	return func(capacity int64) lru.Policy { return lru.NewLFU(capacity, nil) }
+}
+
+// ARCPolicy 在近期性与频繁性之间自适应
+func ARCPolicy() Policy {
+	return func(capacity int64) lru.Policy { return lru.NewARC(capacity, nil) }
+}
+
+// TwoQPolicy 使用FIFO+LRU双队列的2Q 可抵御一次性扫描
+func TwoQPolicy() Policy {
+	return func(capacity int64) lru.Policy { return lru.NewTwoQ(capacity, nil) }
+}
+
+// WTinyLFUPolicy 由TinyLFU准入过滤器决定新记录能否淘汰旧记录 可抵御长尾流量
+func WTinyLFUPolicy() Policy {
+	return func(capacity int64) lru.Policy { return lru.NewWTinyLFU(capacity, nil) }
+}
+
+// ClockPolicy 使用CLOCK算法 命中时只需持有读锁 适合读多写少的场景
+func ClockPolicy() Policy {
+	return func(capacity int64) lru.Policy { return lru.NewClock(capacity, nil) }
+}
+
+const (
+	defaultShards = 16       // cache默认分片数
+	minShardBytes = 64 << 10 // 自动决定分片数时 每个分片的最小容量
+)
+
+// EvictReason 描述一条缓存记录被移除的原因
+// 前四种与 lru.Reason 一一对应
+type EvictReason int
+
+const (
+	EvictCapacity       EvictReason = iota // 容量不足被淘汰
+	EvictExpired                           // 已过期
+	EvictDeleted                           // 被显式删除
+	EvictReplaced                          // 被新值覆盖
+	EvictOwnershipMoved                    // 节点变化后 key改由其他节点负责
+)
+
+func (r EvictReason) String() string {
+	if r == EvictOwnershipMoved {
+		return "ownership-moved"
+	}
+	return lru.Reason(r).String()
+}
+
+// OnEvicted 当缓存记录被移除时 执行的处理函数
+// 开启内存池时value只在回调期间有效 需要保留请使用ByteSlice()
+type OnEvicted func(key string, value ByteView, reason EvictReason)
+
+// eviction 是一条被移除的缓存记录 在分片解锁后交给OnEvicted处理
+type eviction struct {
+	key    string
+	value  ByteView
+	reason EvictReason
+}
+
+// cacheShard 是cache的一个分片 持有独立的淘汰策略实例与锁
+type cacheShard struct {
+	mu      sync.RWMutex
+	lru     lru.Policy
+	shared  lru.SharedGetter // 淘汰策略支持并发读时不为nil
+	pending []eviction       // 持有锁期间被移除的记录
+	keys    prefixIndex      // 分片中全部key的前缀索引
+}
+
+// cache 按key的哈希值将记录分散至多个分片 各分片独立加锁 减少锁竞争
+// 总容量平均分配给各个分片 各分片各自执行淘汰
 type cache struct {
-	mu       sync.Mutex
-	lru      *lru.Cache
-	capacity int64 // 缓存最大容量
+	capacity  int64      // 缓存最大容量
+	policy    Policy     // 淘汰策略 为nil时使用LRU
+	shardNum  int        // 分片数 为0时根据容量自动决定
+	pool      *slab.Pool // 内存池 不为nil时缓存数据存放在内存池中
+	overhead  int64      // 大于0时 每条记录额外计入的开销(Byte)
+	onEvicted OnEvicted  // 记录被移除时的回调 可以为nil
+	counters  cacheStats
+
+	// onExpired 记录因过期被移除时的回调 在数据归还内存池前调用 可以为nil
+	onExpired func(key string, value ByteView)
+
+	tagMu sync.Mutex
+	tags  map[string]map[string]struct{} // 标签 -> 带有该标签的key 在分片的锁内维护 与各分片的内容保持一致
+
+	once   sync.Once
+	shards []*cacheShard
+
+	sweepOnce sync.Once // 后台清理协程只启动一次
+	closeOnce sync.Once
+	stop      chan struct{} // 通知清理协程退出
 }
 
 func newCache(capacity int64) *cache {
-	return &cache{capacity: capacity}
+	return &cache{capacity: capacity, stop: make(chan struct{})}
+}
+
+// init 创建各个分片 推迟到第一次使用时执行 以便Group的选项设置淘汰策略和分片数
+func (c *cache) init() {
+	c.once.Do(func() {
+		n := c.shardNum
+		if n <= 0 {
+			n = defaultShards
+			if c.capacity != 0 {
+				n = int(min(int64(n), max(c.capacity/minShardBytes, 1)))
+			}
+		}
+		if c.capacity != 0 && int64(n) > c.capacity {
+			n = int(c.capacity)
+		}
+		c.shards = make([]*cacheShard, n)
+		for i := range c.shards {
+			s := &cacheShard{lru: c.newPolicy(c.capacity / int64(n))}
+			s.shared, _ = s.lru.(lru.SharedGetter)
+			// 使用内存池时按块的大小计入 否则maxBytes低估实际占用的内存
+			if c.pool != nil || c.overhead > 0 {
+				s.lru.SetSizer(c.sizer)
+			}
+			// 先暂存被移除的记录 解锁后再执行回调 以免回调中访问cache造成死锁
+			s.lru.SetOnEliminated(func(key string, value lru.Lengthable, reason lru.Reason) {
+				v := value.(ByteView)
+				c.untag(key, v.tags)
+				// 被覆盖时key仍在分片中
+				if reason != lru.ReasonReplaced {
+					s.keys.remove(key)
+				}
+				s.pending = append(s.pending, eviction{key, v, EvictReason(reason)})
+			})
+			c.shards[i] = s
+		}
+	})
+}
+
+// sizer 估算记录实际占用的内存 包括每条记录的额外开销
+// 使用内存池时数据占用的是整个块 按块的大小计算
+func (c *cache) sizer(key string, value lru.Lengthable) int64 {
+	n := int64(value.Len())
+	if c.pool != nil {
+		n = int64(cap(value.(ByteView).b))
+	}
+	return int64(len(key)) + n + c.overhead
+}
+
+// CalibrateOverhead 通过 runtime.MemStats 实测每条缓存记录的额外开销(Byte)
+// 预先准备好samples条记录的key与value 写入LRU前后各做一次GC
+// 堆内存的增量即为链表节点、哈希表等结构带来的开销
+func CalibrateOverhead(samples int) int64 {
+	if samples <= 0 {
+		samples = 10000
+	}
+	keys := make([]string, samples)
+	values := make([]ByteView, samples)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		values[i] = ByteView{b: []byte(keys[i])}
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	l := lru.New(0, nil)
+	for i := range keys {
+		l.Add(keys[i], values[i])
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	// 样本在两次测量时都必须存活 否则其被回收的内存会抵消记录的开销
+	runtime.KeepAlive(l)
+	runtime.KeepAlive(keys)
+	runtime.KeepAlive(values)
+
+	if after.HeapAlloc <= before.HeapAlloc {
+		return EntryOverhead
+	}
+	return int64(after.HeapAlloc-before.HeapAlloc) / int64(samples)
+}
+
+// shard 返回key所在的分片
+func (c *cache) shard(key string) *cacheShard {
+	c.init()
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[fnv32(key)%uint32(len(c.shards))]
+}
+
+// fnv32 返回key的FNV-1a哈希值
+func fnv32(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
 }
 
 func (c *cache) add(key string, value ByteView) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.addWithTTL(key, value, 0)
+}
+
+// addWithTTL 写入缓存 ttl > 0 时该记录将在ttl后过期
+// 使用内存池时 value会被复制到内存池中 调用者可以继续持有value
+func (c *cache) addWithTTL(key string, value ByteView, ttl time.Duration) {
+	if c.pool != nil {
+		value.b = c.pool.Copy(value.b)
+	}
+	s := c.shard(key)
+	s.mu.Lock()
+	start := len(s.pending)
+	s.lru.AddWithTTL(key, value, ttl)
+	if !rejected(s.pending[start:], key) {
+		s.keys.insert(key)
+		if len(value.tags) > 0 {
+			c.tag(key, value.tags)
+		}
+	}
+	c.unlock(s)
 
-	if c.lru == nil {
-		c.lru = lru.New(c.capacity, nil)
+	// 出现带过期时间的记录后 才启动后台清理协程
+	if ttl > 0 {
+		c.sweepOnce.Do(func() { go c.sweep() })
 	}
-	c.lru.Add(key, value)
 }
 
 func (c *cache) get(key string) (ByteView, bool) {
-	if c.lru == nil {
+	c.counters.gets.Add(1)
+	view, ok := c.lookup(key)
+	if ok {
+		c.counters.hits.Add(1)
+	}
+	return view, ok
+}
+
+// lookup 与 get 相同 但不计入统计 用于内部读取当前的值
+func (c *cache) lookup(key string) (ByteView, bool) {
+	s := c.shard(key)
+	if s.shared != nil {
+		// 命中不修改内部结构的淘汰策略(如CLOCK) 读锁即可
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if v, ok := s.shared.GetShared(key); ok {
+			return c.view(v.(ByteView)), true
+		}
 		return ByteView{}, false
 	}
 	// 注意：Get操作需要修改lru中的双向链表，需要使用互斥锁。
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	s.mu.Lock()
+	v, ok := s.lru.Get(key)
+	var view ByteView
+	if ok {
+		view = c.view(v.(ByteView))
+	}
+	c.unlock(s)
+	return view, ok
+}
+
+// remove 删除key对应的缓存 返回key是否存在
+func (c *cache) remove(key string) bool {
+	s := c.shard(key)
+	s.mu.Lock()
+	ok := s.lru.Delete(key)
+	c.unlock(s)
+	return ok
+}
+
+// removeFunc 移除所有match返回true的key 并以reason通知OnEvicted 返回移除的个数
+func (c *cache) removeFunc(match func(key string) bool, reason EvictReason) int {
+	c.init()
+	removed := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for _, key := range s.lru.Keys() {
+			if !match(key) {
+				continue
+			}
+			start := len(s.pending)
+			if s.lru.Delete(key) {
+				removed++
+				for i := start; i < len(s.pending); i++ {
+					s.pending[i].reason = reason
+				}
+			}
+		}
+		c.unlock(s)
+	}
+	return removed
+}
+
+// rejected 判断刚写入的key是否立即被淘汰(如容量不足或未通过准入) 被覆盖的旧值不算
+func rejected(evicted []eviction, key string) bool {
+	for _, e := range evicted {
+		if e.key == key && e.reason != EvictReplaced {
+			return true
+		}
+	}
+	return false
+}
+
+// tag 将key加入各个标签的索引 调用时需持有key所在分片的锁
+func (c *cache) tag(key string, tags []string) {
+	c.tagMu.Lock()
+	defer c.tagMu.Unlock()
+
+	if c.tags == nil {
+		c.tags = make(map[string]map[string]struct{})
+	}
+	for _, t := range tags {
+		keys, ok := c.tags[t]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[t] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// untag 将key移出各个标签的索引 调用时需持有key所在分片的锁
+func (c *cache) untag(key string, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	c.tagMu.Lock()
+	defer c.tagMu.Unlock()
+
+	for _, t := range tags {
+		if keys, ok := c.tags[t]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(c.tags, t)
+			}
+		}
+	}
+}
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), true
+// removeTag 删除带有tag的全部key 返回删除的个数
+func (c *cache) removeTag(tag string) int {
+	c.tagMu.Lock()
+	keys := make([]string, 0, len(c.tags[tag]))
+	for key := range c.tags[tag] {
+		keys = append(keys, key)
 	}
-	return ByteView{}, false
+	c.tagMu.Unlock()
+
+	removed := 0
+	for _, key := range keys {
+		if c.remove(key) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// removePrefix 删除以prefix开头的全部key 返回删除的个数
+// 经由各分片的前缀索引找到匹配的key 只访问匹配的部分
+func (c *cache) removePrefix(prefix string) int {
+	c.init()
+	removed := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for _, key := range s.keys.match(prefix) {
+			if s.lru.Delete(key) {
+				removed++
+			}
+		}
+		c.unlock(s)
+	}
+	return removed
+}
+
+// unlock 释放分片的锁 并处理持有锁期间被移除的记录
+func (c *cache) unlock(s *cacheShard) {
+	evicted := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	for _, e := range evicted {
+		if e.reason != EvictReplaced {
+			c.counters.evictions.Add(1)
+		}
+		if c.onEvicted != nil {
+			c.onEvicted(e.key, e.value, e.reason)
+		}
+		if c.onExpired != nil && e.reason == EvictExpired {
+			c.onExpired(e.key, e.value)
+		}
+		// 被移除的数据归还内存池
+		if c.pool != nil {
+			c.pool.Free(e.value.b)
+		}
+	}
+}
+
+// view 返回可以交给调用者的ByteView 调用时需持有分片的锁
+// 内存池中的数据被淘汰后会被复用 而调用者持有ByteView的时间无从得知 因此必须在锁内复制一份
+// 开启内存池后每次命中都会分配一次 换取cache中的数据不被GC扫描 见 BenchmarkGetMemoryPool
+func (c *cache) view(v ByteView) ByteView {
+	if c.pool != nil {
+		v.b = cloneBytes(v.b)
+	}
+	return v
+}
+
+// len 返回所有分片的记录总数
+func (c *cache) len() int {
+	c.init()
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += s.lru.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// bytes 返回所有分片占用的总字节数
+func (c *cache) bytes() int64 {
+	c.init()
+	var n int64
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += s.lru.Bytes()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+func (c *cache) newPolicy(capacity int64) lru.Policy {
+	if c.policy == nil {
+		return lru.New(capacity, nil)
+	}
+	return c.policy(capacity)
+}
+
+// sweep 定期清理已过期但未被访问到的缓存 回收其占用的内存
+func (c *cache) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, s := range c.shards {
+				s.mu.Lock()
+				s.lru.RemoveExpired()
+				c.unlock(s)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// close 停止后台清理协程 cache关闭后不应再被使用
+func (c *cache) close() {
+	c.closeOnce.Do(func() { close(c.stop) })
 }
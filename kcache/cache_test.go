@@ -0,0 +1,88 @@
+package kcache
+
+import (
+	"fmt"
+	"testing"
+
+	"kcache/kcache/slab"
+)
+
+// BenchmarkGetParallel 比较并发读时CLOCK与LRU经由cache.get的吞吐
+// 单分片时所有读者竞争同一把锁 更能体现CLOCK命中只需读锁的优势
+func BenchmarkGetParallel(b *testing.B) {
+	const n = 1 << 14
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	for _, policy := range []struct {
+		name   string
+		policy Policy
+	}{
+		{"Clock", ClockPolicy()},
+		{"LRU", LRUPolicy()},
+	} {
+		for _, shards := range []int{1, defaultShards} {
+			b.Run(fmt.Sprintf("%s/shards=%d", policy.name, shards), func(b *testing.B) {
+				c := newCache(0)
+				c.policy = policy.policy
+				c.shardNum = shards
+				for _, key := range keys {
+					c.add(key, ByteView{b: []byte("value")})
+				}
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for i := 0; pb.Next(); i++ {
+						c.get(keys[i%n])
+					}
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkGetMemoryPool 比较开启内存池前后命中的开销
+// 开启内存池后每次命中都要复制一份数据 换取cache中的数据不被GC扫描
+func BenchmarkGetMemoryPool(b *testing.B) {
+	const n = 1 << 14
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	value := make([]byte, 256)
+
+	for _, pooled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("pool=%v", pooled), func(b *testing.B) {
+			c := newCache(0)
+			if pooled {
+				c.pool = slab.New()
+			}
+			for _, key := range keys {
+				c.add(key, ByteView{b: value})
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.get(keys[i%n])
+			}
+		})
+	}
+}
+
+// TestPoolSizer 开启内存池后 记录按所占块的大小计入容量
+func TestPoolSizer(t *testing.T) {
+	c := newCache(0)
+	c.pool = slab.New()
+	c.shardNum = 1
+	value := make([]byte, 100)
+	c.add("key", ByteView{b: value})
+
+	chunk := cap(c.pool.Copy(value))
+	if chunk <= len(value) {
+		t.Fatalf("chunk of %d bytes for a %d byte value", chunk, len(value))
+	}
+	if got, want := c.bytes(), int64(len("key")+chunk); got != want {
+		t.Fatalf("bytes = %d, want %d", got, want)
+	}
+}
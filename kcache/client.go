@@ -6,62 +6,332 @@ package kcache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	pb "kcache/kcache/kcachepb"
 	"kcache/kcache/registry"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
 )
 
 // client 模块实现peanutcache访问其他远程节点 从而获取缓存的能力
 
+// rpcTimeout 调用者未设置截止时间时 一次rpc调用的超时时间
+const rpcTimeout = 10 * time.Second
+
 type client struct {
-	name string // 服务名称 kcache/ip:addr
+	name   string          // 服务名称 kcache/ip:addr
+	target string          // 服务在etcd中的前缀 即Config.Service
+	etcd   clientv3.Config // 发现服务时连接etcd的配置
+
+	mu   sync.Mutex
+	conn *grpc.ClientConn // 第一次调用时建立 之后复用
 }
 
 // Fetch 从remote peer获取对应缓存值
-func (c *client) Fetch(group string, key string) ([]byte, error) {
+func (c *client) Fetch(ctx context.Context, group string, key string) (Result, error) {
+	var resp *pb.GetResponse
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.KCacheClient) (err error) {
+		log.Println("grpcClient.Get")
+		resp, err = grpcClient.Get(ctx, &pb.GetRequest{
+			Group: group,
+			Key:   key,
+		})
+		return err
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("could not get %s/%s from peer %s: %v", group, key, c.name, err)
+	}
+
+	return resultFromResponse(resp), nil
+}
+
+// resultFromResponse 将GetResponse还原为Result
+func resultFromResponse(resp *pb.GetResponse) Result {
+	return Result{
+		Value:   resp.GetValue(),
+		TTL:     time.Duration(resp.GetTtl()) * time.Millisecond,
+		ETag:    resp.GetEtag(),
+		NoCache: resp.GetNoCache(),
+		Tags:    resp.GetTags(),
+		Version: resp.GetVersion(),
+	}
+}
+
+// Delete 删除remote peer上对应的缓存
+func (c *client) Delete(group string, key string) error {
+	err := c.call(context.Background(), func(ctx context.Context, grpcClient pb.KCacheClient) error {
+		_, err := grpcClient.Delete(ctx, &pb.DeleteRequest{
+			Group: group,
+			Key:   key,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not delete %s/%s from peer %s: %v", group, key, c.name, err)
+	}
+	return nil
+}
+
+// Set 将缓存值写入remote peer
+func (c *client) Set(group string, key string, value []byte, ttl time.Duration, tags ...string) error {
+	err := c.call(context.Background(), func(ctx context.Context, grpcClient pb.KCacheClient) error {
+		_, err := grpcClient.Set(ctx, &pb.SetRequest{
+			Group: group,
+			Key:   key,
+			Value: value,
+			Ttl:   ttl.Milliseconds(),
+			Tags:  tags,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not set %s/%s to peer %s: %v", group, key, c.name, err)
+	}
+	return nil
+}
+
+// Fill 将本地加载的结果写回remote peer
+func (c *client) Fill(ctx context.Context, group string, key string, value []byte, ttl time.Duration, tags ...string) error {
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.KCacheClient) error {
+		_, err := grpcClient.Set(ctx, &pb.SetRequest{
+			Group: group,
+			Key:   key,
+			Value: value,
+			Ttl:   ttl.Milliseconds(),
+			Tags:  tags,
+			Fill:  true,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not fill %s/%s to peer %s: %v", group, key, c.name, err)
+	}
+	return nil
+}
+
+// CompareAndSwap 在remote peer上比较key的版本 与expected相同时写入value
+// 版本不同时返回 ErrVersionMismatch 与当前的版本
+func (c *client) CompareAndSwap(group string, key string, expected uint64, value []byte, ttl time.Duration, tags ...string) (uint64, error) {
+	var resp *pb.CompareAndSwapResponse
+	err := c.call(context.Background(), func(ctx context.Context, grpcClient pb.KCacheClient) (err error) {
+		resp, err = grpcClient.CompareAndSwap(ctx, &pb.CompareAndSwapRequest{
+			Group:    group,
+			Key:      key,
+			Expected: expected,
+			Value:    value,
+			Ttl:      ttl.Milliseconds(),
+			Tags:     tags,
+		})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not compare and swap %s/%s on peer %s: %v", group, key, c.name, err)
+	}
+	if !resp.GetSwapped() {
+		return resp.GetVersion(), ErrVersionMismatch
+	}
+	return resp.GetVersion(), nil
+}
+
+// Incr 将remote peer上key对应的计数器增加delta 返回增加后的值
+func (c *client) Incr(group string, key string, delta int64, ttl time.Duration) (int64, error) {
+	var resp *pb.IncrResponse
+	err := c.call(context.Background(), func(ctx context.Context, grpcClient pb.KCacheClient) (err error) {
+		resp, err = grpcClient.Incr(ctx, &pb.IncrRequest{
+			Group: group,
+			Key:   key,
+			Delta: delta,
+			Ttl:   ttl.Milliseconds(),
+		})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not incr %s/%s on peer %s: %v", group, key, c.name, err)
+	}
+	return resp.GetValue(), nil
+}
+
+// AcquireLease 向remote peer申请key的加载lease 取得时返回其id
+// 其他节点持有lease时 remote peer会等待其释放或过期后返回0
+func (c *client) AcquireLease(ctx context.Context, group string, key string, ttl time.Duration) (uint64, error) {
+	var resp *pb.LeaseResponse
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.KCacheClient) (err error) {
+		resp, err = grpcClient.Lease(ctx, &pb.LeaseRequest{
+			Group: group,
+			Key:   key,
+			Ttl:   ttl.Milliseconds(),
+		})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not acquire lease of %s/%s from peer %s: %v", group, key, c.name, err)
+	}
+	if !resp.GetGranted() {
+		return 0, nil
+	}
+	return resp.GetId(), nil
+}
+
+// TryAcquireLeases 通过一次调用向remote peer申请多个key的加载lease
+// 其他节点持有lease的key不等待 也不出现在返回的结果中
+func (c *client) TryAcquireLeases(ctx context.Context, group string, keys []string, ttl time.Duration) (map[string]uint64, error) {
+	var resp *pb.LeaseResponse
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.KCacheClient) (err error) {
+		resp, err = grpcClient.Lease(ctx, &pb.LeaseRequest{
+			Group:  group,
+			Keys:   keys,
+			Ttl:    ttl.Milliseconds(),
+			NoWait: true,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not acquire leases of %d keys from peer %s: %v", len(keys), c.name, err)
+	}
+	return resp.GetIds(), nil
+}
+
+// ReleaseLease 释放在remote peer上取得的加载lease
+func (c *client) ReleaseLease(ctx context.Context, group string, key string, id uint64) error {
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.KCacheClient) error {
+		_, err := grpcClient.Lease(ctx, &pb.LeaseRequest{
+			Group:   group,
+			Key:     key,
+			Release: id,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not release lease of %s/%s on peer %s: %v", group, key, c.name, err)
+	}
+	return nil
+}
+
+// Invalidate 按标签或前缀删除remote peer上的缓存 返回其删除的key的个数
+func (c *client) Invalidate(group string, tag string, prefix string) (int, error) {
+	var resp *pb.InvalidateResponse
+	err := c.call(context.Background(), func(ctx context.Context, grpcClient pb.KCacheClient) (err error) {
+		resp, err = grpcClient.Invalidate(ctx, &pb.InvalidateRequest{
+			Group:  group,
+			Tag:    tag,
+			Prefix: prefix,
+		})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not invalidate %s on peer %s: %v", group, c.name, err)
+	}
+	return int(resp.GetRemoved()), nil
+}
+
+// FetchMulti 通过一次rpc从remote peer获取多个key的缓存值
+// errs 记录远端获取失败的key 返回的error代表整个rpc调用失败
+func (c *client) FetchMulti(ctx context.Context, group string, keys []string) (values map[string]Result, errs map[string]error, err error) {
+	var resp *pb.GetMultiResponse
+	err = c.call(ctx, func(ctx context.Context, grpcClient pb.KCacheClient) (err error) {
+		resp, err = grpcClient.GetMulti(ctx, &pb.GetMultiRequest{
+			Group: group,
+			Keys:  keys,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get %d keys of %s from peer %s: %v", len(keys), group, c.name, err)
+	}
+
+	errs = make(map[string]error, len(resp.GetErrors()))
+	for key, msg := range resp.GetErrors() {
+		errs[key] = errors.New(msg)
+	}
+	values = make(map[string]Result, len(resp.GetValues()))
+	for key, v := range resp.GetValues() {
+		values[key] = resultFromResponse(v)
+	}
+	return values, errs, nil
+}
+
+// call 在与remote peer的连接上执行一次rpc调用
+// ctx没有截止时间时 使用默认的rpcTimeout 请求ID经grpc metadata传递至远端
+func (c *client) call(ctx context.Context, rpc func(ctx context.Context, grpcClient pb.KCacheClient) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	grpcClient := pb.NewKCacheClient(conn)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rpcTimeout)
+		defer cancel()
+	}
+
+	return rpc(outgoingContext(ctx), grpcClient)
+}
+
+// dial 返回与remote peer的连接 第一次调用时通过etcd发现服务并建立连接
+// grpc连接断开后会自动重连 因此连接建立后一直复用 直到close
+func (c *client) dial() (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		return c.conn, nil
+	}
 
 	// 创建一个etcd client
-	cli, err := clientv3.New(defaultEtcdConfig)
+	cli, err := clientv3.New(c.etcd)
 	if err != nil {
-		log.Printf("clientv3.New(defaultEtcdConfig) fail")
+		log.Printf("clientv3.New(c.etcd) fail")
 		return nil, err
 	}
 	defer cli.Close()
 	// 发现服务 取得与服务的连接
 
 	log.Println("registry.EtcdDial: " + c.name)
-	conn, err := registry.EtcdDial(cli, c.name)
+	conn, err := registry.EtcdDial(cli, c.target, c.name)
 	if err != nil {
-		log.Printf("registry.EtcdDial(cli, c.name) fail")
+		log.Printf("registry.EtcdDial(cli, c.target, c.name) fail")
 		return nil, err
 	}
-	defer conn.Close()
+	c.conn = conn
+	return conn, nil
+}
 
-	log.Println("pb.NewKCacheClient(conn)")
-	grpcClient := pb.NewKCacheClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// close 关闭与remote peer的连接
+func (c *client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	log.Println("grpcClient.Get")
-	resp, err := grpcClient.Get(ctx, &pb.GetRequest{
-		Group: group,
-		Key:   key,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("could not get %s/%s from peer %s", group, key, c.name)
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
 	}
-
-	return resp.GetValue(), nil
 }
 
 func NewClient(service string) *client {
-	return &client{name: service}
+	return newClient(service, defaultInstance.config)
+}
+
+func newClient(service string, config Config) *client {
+	return &client{name: service, target: config.Service, etcd: config.Etcd}
 }
 
-// 测试Client是否实现了Fetcher接口
+// 测试Client是否实现了peers模块定义的各个接口
 var _ Fetcher = (*client)(nil)
+var _ MultiFetcher = (*client)(nil)
+var _ Setter = (*client)(nil)
+var _ Filler = (*client)(nil)
+var _ Deleter = (*client)(nil)
+var _ Invalidator = (*client)(nil)
+var _ Swapper = (*client)(nil)
+var _ Incrementer = (*client)(nil)
+var _ Leaser = (*client)(nil)
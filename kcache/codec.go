@@ -0,0 +1,196 @@
+package kcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"kcache/kcache/lru"
+	"reflect"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// codec 模块提供结构化数据的编解码
+// GetTyped/SetTyped 在缓存的[]byte与调用者的类型之间转换 TypedRetriever 在加载时编码
+
+// Codec 定义了对象与缓存中[]byte之间的转换
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal 将data解码至v v是指向目标对象的指针
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec 使用encoding/json编解码
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec 使用encoding/gob编解码 每个值都带有完整的类型信息
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ProtoCodec 使用protobuf编解码 对象必须是proto.Message或指向它的指针
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		// 生成的消息类型只有指针实现了proto.Message
+		rv := reflect.New(reflect.TypeOf(v))
+		rv.Elem().Set(reflect.ValueOf(v))
+		if m, ok = rv.Interface().(proto.Message); !ok {
+			return nil, fmt.Errorf("%T is not a proto.Message", v)
+		}
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		// v为**Msg时 为*Msg分配一个新的消息
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Ptr {
+			return fmt.Errorf("%T is not a pointer to proto.Message", v)
+		}
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if m, ok = rv.Elem().Interface().(proto.Message); !ok {
+			return fmt.Errorf("%T is not a pointer to proto.Message", v)
+		}
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// GetTyped 获取key对应的缓存值 并经codec解码为T
+func GetTyped[T any](g *Group, key string, codec Codec) (T, error) {
+	return GetTypedContext[T](context.Background(), g, key, codec)
+}
+
+// GetTypedContext 与 GetTyped 相同 ctx的截止时间与取消会传递给远端节点与Retriever
+// Group开启了 WithDecodedCache 时 同一份缓存值只解码一次 返回的对象被多个调用者共享 不应被修改
+func GetTypedContext[T any](ctx context.Context, g *Group, key string, codec Codec) (T, error) {
+	var v T
+	view, err := g.GetContext(ctx, key)
+	if err != nil {
+		return v, err
+	}
+
+	if g.decoded != nil {
+		if cached, ok := g.decoded.get(key, view); ok {
+			if v, ok := cached.(T); ok {
+				return v, nil
+			}
+		}
+	}
+	if err := codec.Unmarshal(view.b, &v); err != nil {
+		return v, fmt.Errorf("decode %s: %v", key, err)
+	}
+	if g.decoded != nil {
+		g.decoded.add(key, view, v)
+	}
+	return v, nil
+}
+
+// SetTyped 将v经codec编码后写入key
+func SetTyped[T any](g *Group, key string, v T, codec Codec, opts ...SetOption) error {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %v", key, err)
+	}
+	return g.Set(key, data, opts...)
+}
+
+// TypedRetriever 将返回T的加载函数转换为 Retriever 取回的对象经codec编码后缓存
+func TypedRetriever[T any](codec Codec, fn func(ctx context.Context, key string) (T, error)) Retriever {
+	return RetrieverContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		v, err := fn(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(v)
+	})
+}
+
+// WithDecodedCache 令 GetTyped 在本节点缓存最多n个解码后的对象
+// cache或hotcache命中同一版本的缓存值时直接返回上次解码的对象 缓存值被覆盖后自动失效
+// n <= 0 时不缓存解码后的对象
+func WithDecodedCache(n int) GroupOption {
+	return func(g *Group) {
+		if n <= 0 {
+			g.decoded = nil
+			return
+		}
+		l := lru.New(int64(n), nil)
+		l.SetSizer(func(string, lru.Lengthable) int64 { return 1 })
+		g.decoded = &decodedCache{lru: l}
+	}
+}
+
+// decodedCache 按key缓存解码后的对象 并记录其来源的缓存值的版本
+// 每次写入都会分配新的版本 版本相同即是同一份缓存值 与数据是否被复制无关
+type decodedCache struct {
+	mu  sync.Mutex
+	lru *lru.Cache
+}
+
+// decodedEntry 是一个解码后的对象 version是解码时使用的缓存值的版本
+type decodedEntry struct {
+	version uint64
+	value   interface{}
+}
+
+func (e *decodedEntry) Len() int {
+	return 1
+}
+
+// get 返回view解码后的对象 view与上次解码时不是同一版本时返回false
+func (c *decodedCache) get(key string, view ByteView) (interface{}, bool) {
+	// 未分配版本的值(如NoCache)无法判断是否是同一份
+	if view.version == 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	e := v.(*decodedEntry)
+	if e.version != view.version {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *decodedCache) add(key string, view ByteView, value interface{}) {
+	if view.version == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Add(key, &decodedEntry{version: view.version, value: value})
+}
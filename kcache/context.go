@@ -0,0 +1,46 @@
+package kcache
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// context 模块负责在节点之间传递请求ID
+// 截止时间由grpc随ctx自动传递 请求ID则放在grpc metadata中
+
+// requestIDHeader 请求ID在grpc metadata中的key
+const requestIDHeader = "x-request-id"
+
+type requestIDKey struct{}
+
+// WithRequestID 返回携带请求ID的ctx
+// 经由该ctx发往远端节点的rpc会带上这个ID 远端Server处理请求时可以取回
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext 返回ctx携带的请求ID 没有时返回空串
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// outgoingContext 将ctx中的请求ID写入发往远端的grpc metadata
+func outgoingContext(ctx context.Context) context.Context {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, requestIDHeader, id)
+	}
+	return ctx
+}
+
+// requestIDInterceptor 从收到的grpc metadata中取出请求ID 放入handler的ctx
+func requestIDInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDHeader); len(ids) > 0 && ids[0] != "" {
+			ctx = WithRequestID(ctx, ids[0])
+		}
+	}
+	return handler(ctx, req)
+}
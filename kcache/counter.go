@@ -0,0 +1,279 @@
+package kcache
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// counter 模块实现原子计数器
+// 计数器保存在负责该key的节点的计数器表中 不计入cache的容量 也不会因容量不足被淘汰
+// cache中另存一份十进制字符串形式的副本 可以直接通过Get读取 副本被淘汰后从计数器表恢复
+// 增减在该节点上持有key的锁完成 不会写入数据源 也不会广播至其他节点
+// 因此其他节点hotcache中的副本可能落后 需要准确值时应读取Incr的返回值
+// 节点变化后 改由其他节点负责的计数器会累加至新的负责节点 而不是随cache一起丢弃
+
+// Incr 将key对应的计数器增加delta 返回增加后的值
+// key不存在时从0开始 新记录在 ExpireAfter 指定的时间后过期 默认使用Group的过期时间
+// 已存在的记录保留原有的过期时间 因此可以用作固定窗口的频率计数
+// 计数器只保存在负责节点的内存中 该节点退出后计数器随之丢失
+func (g *Group) Incr(key string, delta int64, opts ...SetOption) (int64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("key required")
+	}
+	o := setOptions{ttl: g.ttl}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if g.server != nil {
+		if owner, remote := g.server.Pick(key); remote {
+			incrementer, ok := owner.(Incrementer)
+			if !ok {
+				return 0, fmt.Errorf("peer of *%s* does not support incr", key)
+			}
+			n, err := incrementer.Incr(g.name, key, delta, o.ttl)
+			if err != nil {
+				return 0, err
+			}
+			// 本节点不负责该key 删除本地可能残留的旧副本
+			// 计数器表中尚未交给新负责节点的计数器保留 由handOffCounters累加过去
+			g.cache.remove(key)
+			g.hotcache.remove(key)
+			return n, nil
+		}
+	}
+	return g.incrLocally(key, delta, o.ttl)
+}
+
+// Decr 将key对应的计数器减少delta 返回减少后的值
+func (g *Group) Decr(key string, delta int64, opts ...SetOption) (int64, error) {
+	return g.Incr(key, -delta, opts...)
+}
+
+// incrLocally 在本节点的计数器表上增加计数器 并更新cache中的副本
+// 计数器不存在时 以cache中由Set写入的值为初始值
+func (g *Group) incrLocally(key string, delta int64, ttl time.Duration) (int64, error) {
+	mu := g.lockKey(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	c, ok := g.counters.get(key)
+	if !ok {
+		if view, ok := g.cache.lookup(key); ok {
+			n, err := strconv.ParseInt(view.String(), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("value of *%s* is not an integer", key)
+			}
+			c = counter{n: n, expire: view.expire, tags: view.tags}
+		} else if ttl > 0 {
+			c.expire = time.Now().Add(ttl)
+		}
+	}
+
+	c.n += delta
+	g.counters.set(key, c)
+	g.hotcache.remove(key)
+	g.populateCounter(key, c)
+	return c.n, nil
+}
+
+// populateCounter 将计数器的副本写入cache 调用时需持有key的锁
+func (g *Group) populateCounter(key string, c counter) ByteView {
+	var ttl time.Duration
+	if !c.expire.IsZero() {
+		ttl = max(time.Until(c.expire), time.Millisecond)
+	}
+	return g.populateCache(key, ByteView{b: []byte(strconv.FormatInt(c.n, 10)), tags: c.tags}, ttl)
+}
+
+// loadCounter cache中计数器的副本被淘汰后 从计数器表恢复 key不是计数器时返回false
+func (g *Group) loadCounter(key string) (ByteView, bool) {
+	mu := g.lockKey(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	c, ok := g.counters.get(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	return g.populateCounter(key, c), true
+}
+
+// handOffCounters 节点变化后 将改由其他节点负责的计数器累加至新的负责节点
+// 交接失败的计数器保留在本节点 下次节点变化时重试
+func (g *Group) handOffCounters(svr *Server) {
+	handed := 0
+	for _, key := range g.counters.keys(func(key string) bool { return !svr.owns(key) }) {
+		owner, ok := svr.Pick(key)
+		if !ok {
+			continue
+		}
+		incrementer, ok := owner.(Incrementer)
+		if !ok {
+			continue
+		}
+		if g.handOffCounter(svr, incrementer, key) {
+			handed++
+		}
+	}
+	if handed > 0 {
+		log.Printf("[%s] hand off %d counters to other peers", g.name, handed)
+	}
+}
+
+// handOffCounter 将key的计数器累加至新的负责节点 成功后从本节点移除
+func (g *Group) handOffCounter(svr *Server, owner Incrementer, key string) bool {
+	mu := g.lockKey(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	c, ok := g.counters.get(key)
+	// 期间节点可能再次变化 key又改由本节点负责
+	if !ok || svr.owns(key) {
+		return false
+	}
+	var ttl time.Duration
+	if !c.expire.IsZero() {
+		ttl = max(time.Until(c.expire), time.Millisecond)
+	}
+	if _, err := owner.Incr(g.name, key, c.n, ttl); err != nil {
+		log.Printf("fail to hand off counter *%s*, %s.\n", key, err.Error())
+		return false
+	}
+	g.counters.remove(key)
+	return true
+}
+
+// counter 是一个计数器 expire为零值时永不过期
+type counter struct {
+	n      int64
+	expire time.Time
+	tags   []string
+}
+
+func (c *counter) expired(now time.Time) bool {
+	return !c.expire.IsZero() && now.After(c.expire)
+}
+
+// counterTable 保存本节点负责的计数器 零值即可使用
+// 计数器只在过期、被删除或交给新的负责节点时移除
+type counterTable struct {
+	mu       sync.Mutex
+	counters map[string]counter
+	stop     chan struct{} // 出现带过期时间的计数器后才启动后台清理协程
+	closed   bool
+}
+
+// get 返回key对应的计数器 已过期的计数器在此时被惰性删除
+func (t *counterTable) get(key string) (counter, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.counters[key]
+	if !ok {
+		return counter{}, false
+	}
+	if c.expired(time.Now()) {
+		delete(t.counters, key)
+		return counter{}, false
+	}
+	return c, true
+}
+
+func (t *counterTable) set(key string, c counter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.counters == nil {
+		t.counters = make(map[string]counter)
+	}
+	t.counters[key] = c
+	if !c.expire.IsZero() && t.stop == nil && !t.closed {
+		t.stop = make(chan struct{})
+		go t.sweep(t.stop)
+	}
+}
+
+// remove 删除key对应的计数器 返回key是否存在
+func (t *counterTable) remove(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.counters[key]
+	delete(t.counters, key)
+	return ok
+}
+
+// removeFunc 删除所有match返回true的计数器 计数器通常不多 直接遍历全部计数器
+func (t *counterTable) removeFunc(match func(key string, c counter) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, c := range t.counters {
+		if match(key, c) {
+			delete(t.counters, key)
+		}
+	}
+}
+
+// removeTag 删除带有tag的计数器
+func (t *counterTable) removeTag(tag string) {
+	t.removeFunc(func(_ string, c counter) bool {
+		return slices.Contains(c.tags, tag)
+	})
+}
+
+// removePrefix 删除以prefix开头的计数器
+func (t *counterTable) removePrefix(prefix string) {
+	t.removeFunc(func(key string, _ counter) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// keys 返回所有match返回true的计数器的key
+func (t *counterTable) keys(match func(key string) bool) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var keys []string
+	for key := range t.counters {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// sweep 定期删除已过期的计数器 直到close
+func (t *counterTable) sweep(stop chan struct{}) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			t.removeFunc(func(_ string, c counter) bool {
+				return c.expired(now)
+			})
+		case <-stop:
+			return
+		}
+	}
+}
+
+// close 停止后台清理协程
+func (t *counterTable) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stop != nil && !t.closed {
+		close(t.stop)
+	}
+	t.closed = true
+}
@@ -0,0 +1,91 @@
+package kcache
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// countingRetriever 记录Retriever被调用的次数 总是返回错误
+func countingRetriever(calls *atomic.Int32) Retriever {
+	return RetrieverFunc(func(key string) ([]byte, error) {
+		calls.Add(1)
+		return nil, errors.New("not found")
+	})
+}
+
+// TestIncrSurvivesEviction cache中计数器的副本被淘汰后 计数器不会归零
+func TestIncrSurvivesEviction(t *testing.T) {
+	var calls atomic.Int32
+	g := newGroup("counter", 256, countingRetriever(&calls))
+	defer g.close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := g.Incr("views", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		g.Set(fmt.Sprintf("key%d", i), make([]byte, 32))
+	}
+	if _, ok := g.cache.lookup("views"); ok {
+		t.Fatalf("counter copy was not evicted")
+	}
+
+	view, err := g.Get("views")
+	if err != nil || view.String() != "5" {
+		t.Fatalf("Get = %q, %v, want 5", view.String(), err)
+	}
+	if calls.Load() != 0 {
+		t.Fatalf("retriever called %d times for a counter", calls.Load())
+	}
+	if n, err := g.Incr("views", 1); n != 6 || err != nil {
+		t.Fatalf("Incr = %d, %v, want 6", n, err)
+	}
+}
+
+func TestIncrAfterSet(t *testing.T) {
+	var calls atomic.Int32
+	g := newGroup("counter", 0, countingRetriever(&calls))
+	defer g.close()
+
+	g.Set("n", []byte("10"))
+	if n, _ := g.Incr("n", 1); n != 11 {
+		t.Fatalf("Incr = %d, want 11", n)
+	}
+	// Set覆盖计数器
+	g.Set("n", []byte("3"))
+	if n, _ := g.Incr("n", 1); n != 4 {
+		t.Fatalf("Incr = %d, want 4", n)
+	}
+	if n, _ := g.Decr("n", 5); n != -1 {
+		t.Fatalf("Decr = %d, want -1", n)
+	}
+
+	g.Set("s", []byte("abc"))
+	if _, err := g.Incr("s", 1); err == nil {
+		t.Fatalf("Incr on a non-integer value succeeded")
+	}
+}
+
+func TestIncrRemove(t *testing.T) {
+	var calls atomic.Int32
+	g := newGroup("counter", 0, countingRetriever(&calls))
+	defer g.close()
+
+	g.Incr("n", 7)
+	g.Remove("n")
+	if n, _ := g.Incr("n", 1); n != 1 {
+		t.Fatalf("Incr after Remove = %d, want 1", n)
+	}
+
+	g.Incr("rate:a", 1)
+	g.Incr("rate:b", 1)
+	if _, err := g.invalidateLocally("", "rate:"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := g.Incr("rate:a", 1); n != 1 {
+		t.Fatalf("Incr after prefix invalidation = %d, want 1", n)
+	}
+}
@@ -0,0 +1,271 @@
+package kcache
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// fill 模块协调整个集群对同一key的加载
+// singleflight只能合并同一进程内的加载 负责节点不可达或节点变化期间 各节点会同时调用Retriever
+// 需要在本地加载的节点先向负责该key的节点申请一个短期的lease
+// 取得lease的节点调用Retriever 并将结果写回负责节点 其余节点等待lease释放后从负责节点获取
+// 负责节点不可达时无法协调 各节点直接在本地加载
+
+// defaultFillLease lease的默认有效期 持有者在此期间没有写回时 等待者不再等待
+const defaultFillLease = 3 * time.Second
+
+// WithFillLease 设置加载lease的有效期 为0时不协调 各节点各自加载
+func WithFillLease(d time.Duration) GroupOption {
+	return func(g *Group) {
+		g.fillLease = d
+	}
+}
+
+// lease 是一个key的加载权 done在lease被释放时关闭
+type lease struct {
+	id     uint64
+	expire time.Time
+	done   chan struct{}
+}
+
+// leaseTable 记录本节点负责的key上的lease
+type leaseTable struct {
+	mu     sync.Mutex
+	nextID uint64
+	leases map[string]*lease
+}
+
+// acquire 申请key的lease 成功时返回其id
+// key上已有lease时 等待其被释放或过期后返回0 ctx被取消时同样返回0
+func (t *leaseTable) acquire(ctx context.Context, key string, ttl time.Duration) uint64 {
+	id, l := t.grant(key, ttl)
+	if id != 0 {
+		return id
+	}
+
+	timer := time.NewTimer(time.Until(l.expire))
+	defer timer.Stop()
+	select {
+	case <-l.done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return 0
+}
+
+// tryAcquire 与 acquire 相同 但key上已有lease时立即返回0
+func (t *leaseTable) tryAcquire(key string, ttl time.Duration) uint64 {
+	id, _ := t.grant(key, ttl)
+	return id
+}
+
+// grant 在key上没有有效的lease时创建一个并返回其id 否则返回0与已有的lease
+func (t *leaseTable) grant(key string, ttl time.Duration) (uint64, *lease) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.leases == nil {
+		t.leases = make(map[string]*lease)
+	}
+	l, ok := t.leases[key]
+	if ok && now.Before(l.expire) {
+		return 0, l
+	}
+	t.nextID++
+	l = &lease{id: t.nextID, expire: now.Add(ttl), done: make(chan struct{})}
+	t.leases[key] = l
+	return l.id, l
+}
+
+// release 释放key上id对应的lease id为0时释放key上的任意lease 唤醒全部等待者
+func (t *leaseTable) release(key string, id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if l, ok := t.leases[key]; ok && (id == 0 || l.id == id) {
+		close(l.done)
+		delete(t.leases, key)
+	}
+}
+
+// fillBack 保存其他节点持有lease加载后写回的值 并释放lease
+// 加载期间key若已被写入 则保留写入的值
+func (g *Group) fillBack(key string, value []byte, ttl time.Duration, tags []string) {
+	g.fillCache(key, ByteView{b: value, tags: tags}, ttl)
+	g.leases.release(key, 0)
+}
+
+// fillLocally 本节点负责key时 持有lease从Retriever加载
+// 其他节点持有lease时 等待其写回后直接使用写回的值
+func (g *Group) fillLocally(ctx context.Context, key string) (ByteView, error) {
+	if g.fillLease <= 0 {
+		return g.getLocally(ctx, key)
+	}
+	id := g.leases.acquire(ctx, key, g.fillLease)
+	if id == 0 {
+		if view, ok := g.cache.lookup(key); ok {
+			return view, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return ByteView{}, err
+		}
+		return g.getLocally(ctx, key)
+	}
+	defer g.leases.release(key, id)
+	// 其他节点可能刚写回并释放了lease
+	if view, ok := g.cache.lookup(key); ok {
+		return view, nil
+	}
+	return g.getLocally(ctx, key)
+}
+
+// fillFromPeer 从负责节点获取失败后 在本地加载key
+// 先向负责节点申请lease 取得后从Retriever加载并写回负责节点
+// 未取得时lease已被释放 再次从负责节点获取
+func (g *Group) fillFromPeer(ctx context.Context, fetcher Fetcher, key string) (ByteView, error) {
+	leaser, ok := fetcher.(Leaser)
+	if !ok || g.fillLease <= 0 {
+		return g.getLocally(ctx, key)
+	}
+
+	id, err := leaser.AcquireLease(ctx, g.name, key, g.fillLease)
+	if err != nil {
+		// 负责节点不可达 无法协调
+		log.Printf("fail to acquire fill lease of *%s*, %s.\n", key, err.Error())
+		if ctx.Err() != nil {
+			return ByteView{}, ctx.Err()
+		}
+		return g.getLocally(ctx, key)
+	}
+	if id == 0 {
+		if r, err := fetcher.Fetch(ctx, g.name, key); err == nil {
+			g.stats.peerLoads.Add(1)
+			return g.populateHotCache(key, r), nil
+		}
+		g.stats.peerErrors.Add(1)
+		if ctx.Err() != nil {
+			return ByteView{}, ctx.Err()
+		}
+		return g.getLocally(ctx, key)
+	}
+
+	view, err := g.getLocally(ctx, key)
+	g.writeBack(ctx, fetcher, key, id, view, err)
+	return view, err
+}
+
+// writeBack 将持有lease时加载的结果写回负责节点 写回的同时释放lease
+// 加载失败或无法写回时 显式释放lease 唤醒等待者
+func (g *Group) writeBack(ctx context.Context, owner Fetcher, key string, id uint64, view ByteView, err error) {
+	if err == nil && !view.noCache {
+		if filler, ok := owner.(Filler); ok {
+			err := filler.Fill(ctx, g.name, key, view.b, view.ttl(), view.tags...)
+			if err == nil {
+				return
+			}
+			log.Printf("fail to write back *%s* to peer, %s.\n", key, err.Error())
+		}
+	}
+	if err := owner.(Leaser).ReleaseLease(ctx, g.name, key, id); err != nil {
+		log.Printf("fail to release fill lease of *%s*, %s.\n", key, err.Error())
+	}
+}
+
+// fillMulti 与 fillLocally/fillFromPeer 相同 在本地加载多个key前先为它们申请lease
+// owner为nil代表本节点负责这些key 否则通过一次调用向owner申请全部key的lease
+// 取得lease的key合并为一次批量加载 之后写回owner或释放lease
+// 批量加载期间不等待其他节点持有的lease 以免各节点互相持有对方等待的lease
+// 这些key在批量加载结束后逐个按 fillLocally 或 load 加载
+func (g *Group) fillMulti(ctx context.Context, owner Fetcher, keys []string, res *multiResult) {
+	if _, ok := owner.(Leaser); g.fillLease <= 0 || (owner != nil && !ok) {
+		g.loadMulti(ctx, keys, res)
+		return
+	}
+
+	leases, held := g.tryAcquireFills(ctx, owner, keys)
+	if owner == nil {
+		for key, id := range leases {
+			// 其他节点可能刚写回并释放了lease
+			if view, ok := g.cache.lookup(key); ok {
+				g.leases.release(key, id)
+				delete(leases, key)
+				res.set(key, view, nil)
+			}
+		}
+	}
+
+	if len(leases) > 0 {
+		batch := make([]string, 0, len(leases))
+		for key := range leases {
+			batch = append(batch, key)
+		}
+		loaded := newMultiResult(len(batch))
+		g.loadMulti(ctx, batch, loaded)
+		for _, key := range batch {
+			view, err := loaded.values[key], loaded.errs[key]
+			if id := leases[key]; id != 0 {
+				if owner == nil {
+					g.leases.release(key, id)
+				} else {
+					g.writeBack(ctx, owner, key, id, view, err)
+				}
+			}
+			res.set(key, view, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, key := range held {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			var view ByteView
+			var err error
+			if owner == nil {
+				view, err = g.fillLocally(ctx, key)
+			} else {
+				// 持有者可能已经写回 先从负责节点获取
+				view, err = g.load(ctx, key)
+			}
+			res.set(key, view, err)
+		}(key)
+	}
+	wg.Wait()
+}
+
+// tryAcquireFills 不等待地为keys申请lease 对owner只发送一次调用
+// leases 为需要在本地加载的key及其lease held 为其他节点正持有lease的key
+// owner不可达时无法协调 全部key的lease为0 仍需在本地加载
+func (g *Group) tryAcquireFills(ctx context.Context, owner Fetcher, keys []string) (leases map[string]uint64, held []string) {
+	leases = make(map[string]uint64, len(keys))
+	if owner == nil {
+		for _, key := range keys {
+			if id := g.leases.tryAcquire(key, g.fillLease); id != 0 {
+				leases[key] = id
+			} else {
+				held = append(held, key)
+			}
+		}
+		return leases, held
+	}
+
+	ids, err := owner.(Leaser).TryAcquireLeases(ctx, g.name, keys, g.fillLease)
+	if err != nil {
+		log.Printf("fail to acquire fill leases of %d keys, %s.\n", len(keys), err.Error())
+		for _, key := range keys {
+			leases[key] = 0
+		}
+		return leases, nil
+	}
+	for _, key := range keys {
+		if id, ok := ids[key]; ok {
+			leases[key] = id
+		} else {
+			held = append(held, key)
+		}
+	}
+	return leases, held
+}
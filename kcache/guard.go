@@ -0,0 +1,266 @@
+package kcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// guard 模块在调用Retriever前做准入控制 避免数据源变慢时被持续压垮
+// 依次检查每秒加载次数、同时进行的加载个数与熔断器 任何一项拒绝都不会调用Retriever
+// 被拒绝的加载返回 ErrLoadRejected 或 ErrCircuitOpen 开启 WithStaleCache 时优先返回过期的旧值
+
+var (
+	// ErrLoadRejected 超过了同时加载个数或每秒加载次数的限制
+	ErrLoadRejected = errors.New("kcache: load rejected")
+	// ErrCircuitOpen 熔断器处于打开状态 暂停调用Retriever
+	ErrCircuitOpen = errors.New("kcache: circuit open")
+)
+
+// BreakerConfig 是熔断器的配置 零值字段使用默认值
+type BreakerConfig struct {
+	Window         time.Duration    // 统计错误率与慢调用率的窗口 默认10s
+	MinRequests    int              // 窗口内调用次数达到该值后才会熔断 默认20
+	ErrorRate      float64          // 错误率达到该值时熔断 默认0.5
+	SlowCall       time.Duration    // 耗时超过该值的调用视为慢调用 为0时不统计慢调用
+	SlowRate       float64          // 慢调用率达到该值时熔断 默认0.5
+	OpenTimeout    time.Duration    // 熔断后经过该时间进入半开状态 默认5s
+	HalfOpenProbes int              // 半开状态下放行的探测调用个数 全部成功后恢复 默认1
+	IsFailure      func(error) bool // 判断Retriever返回的error是否计入错误率 默认除ctx取消外的error都计入
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.Window <= 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.ErrorRate <= 0 {
+		c.ErrorRate = 0.5
+	}
+	if c.SlowRate <= 0 {
+		c.SlowRate = 0.5
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = 5 * time.Second
+	}
+	if c.HalfOpenProbes <= 0 {
+		c.HalfOpenProbes = 1
+	}
+	if c.IsFailure == nil {
+		c.IsFailure = func(err error) bool {
+			return !errors.Is(err, context.Canceled)
+		}
+	}
+	return c
+}
+
+// WithCircuitBreaker 为Group的Retriever开启熔断器
+// 错误率或慢调用率过高时熔断 一段时间后放行少量探测调用 探测成功后恢复
+func WithCircuitBreaker(config BreakerConfig) GroupOption {
+	return func(g *Group) {
+		g.breaker = &breaker{config: config.withDefaults()}
+	}
+}
+
+// WithMaxConcurrentLoads 限制同时调用Retriever的个数 超过时立即拒绝 n <= 0 时不限制
+func WithMaxConcurrentLoads(n int) GroupOption {
+	return func(g *Group) {
+		if n <= 0 {
+			g.loadSem = nil
+			return
+		}
+		g.loadSem = make(chan struct{}, n)
+	}
+}
+
+// WithLoadRateLimit 限制每秒调用Retriever的次数 允许burst次的突发 超过时立即拒绝
+// perSecond <= 0 时不限制
+func WithLoadRateLimit(perSecond float64, burst int) GroupOption {
+	return func(g *Group) {
+		if perSecond <= 0 {
+			g.limiter = nil
+			return
+		}
+		g.limiter = &rateLimiter{rate: perSecond, burst: float64(max(burst, 1)), tokens: float64(max(burst, 1))}
+	}
+}
+
+// WithStaleCache 保留cache中最多maxBytes字节已过期的记录
+// 加载被拒绝时返回其中的旧值 此时ByteView.Stale返回true
+func WithStaleCache(maxBytes int64) GroupOption {
+	return func(g *Group) {
+		g.stale = newCache(maxBytes)
+		g.cache.onExpired = func(key string, value ByteView) {
+			value.b = cloneBytes(value.b)
+			value.stale = true
+			g.stale.add(key, value)
+		}
+	}
+}
+
+// admit 判断本次加载能否调用Retriever
+// 放行时返回done 调用结束后需以Retriever的结果与耗时调用它
+func (g *Group) admit() (done func(err error, elapsed time.Duration), err error) {
+	if g.limiter != nil && !g.limiter.allow() {
+		return nil, ErrLoadRejected
+	}
+	if g.loadSem != nil {
+		select {
+		case g.loadSem <- struct{}{}:
+		default:
+			return nil, ErrLoadRejected
+		}
+	}
+	if g.breaker != nil && !g.breaker.allow() {
+		if g.loadSem != nil {
+			<-g.loadSem
+		}
+		return nil, ErrCircuitOpen
+	}
+
+	return func(err error, elapsed time.Duration) {
+		if g.breaker != nil {
+			g.breaker.done(err, elapsed)
+		}
+		if g.loadSem != nil {
+			<-g.loadSem
+		}
+	}, nil
+}
+
+// reject 处理被拒绝的加载 有过期的旧值时返回旧值
+func (g *Group) reject(key string, err error) (ByteView, error) {
+	g.stats.loadRejects.Add(1)
+	if g.stale != nil {
+		if view, ok := g.stale.get(key); ok {
+			g.stats.staleServed.Add(1)
+			return view, nil
+		}
+	}
+	return ByteView{}, err
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker 是Retriever的熔断器
+type breaker struct {
+	config BreakerConfig
+
+	mu          sync.Mutex
+	state       breakerState
+	windowStart time.Time
+	total       int // 窗口内的调用次数
+	failures    int // 窗口内的错误次数
+	slow        int // 窗口内的慢调用次数
+	openedAt    time.Time
+	probes      int // 半开状态下已放行的探测调用个数
+	succeeded   int // 半开状态下已成功的探测调用个数
+}
+
+// allow 判断能否调用Retriever 半开状态下只放行HalfOpenProbes个调用
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probes, b.succeeded = 0, 0
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= b.config.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// done 记录一次调用的结果 决定是否熔断或恢复
+func (b *breaker) done(err error, elapsed time.Duration) {
+	failed := err != nil && b.config.IsFailure(err)
+	slow := b.config.SlowCall > 0 && elapsed > b.config.SlowCall
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case breakerHalfOpen:
+		if failed || slow {
+			b.trip(now)
+			return
+		}
+		b.succeeded++
+		if b.succeeded >= b.config.HalfOpenProbes {
+			b.state = breakerClosed
+			b.reset(now)
+		}
+	case breakerClosed:
+		if now.Sub(b.windowStart) >= b.config.Window {
+			b.reset(now)
+		}
+		b.total++
+		if failed {
+			b.failures++
+		}
+		if slow {
+			b.slow++
+		}
+		if b.total >= b.config.MinRequests &&
+			(float64(b.failures) >= b.config.ErrorRate*float64(b.total) ||
+				float64(b.slow) >= b.config.SlowRate*float64(b.total)) {
+			b.trip(now)
+		}
+	}
+}
+
+// trip 熔断
+func (b *breaker) trip(now time.Time) {
+	b.state = breakerOpen
+	b.openedAt = now
+	b.reset(now)
+}
+
+// reset 开始一个新的统计窗口
+func (b *breaker) reset(now time.Time) {
+	b.windowStart = now
+	b.total, b.failures, b.slow = 0, 0, 0
+}
+
+// rateLimiter 是令牌桶限流器
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的令牌数
+	burst  float64 // 桶的容量
+	tokens float64
+	last   time.Time
+}
+
+func (l *rateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
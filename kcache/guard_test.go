@@ -0,0 +1,168 @@
+package kcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errLoad = errors.New("load failed")
+
+func TestBreakerTrip(t *testing.T) {
+	b := &breaker{config: BreakerConfig{MinRequests: 4, ErrorRate: 0.5, OpenTimeout: 20 * time.Millisecond}.withDefaults()}
+
+	// 调用次数不足MinRequests时不熔断
+	for i := 0; i < 3; i++ {
+		if !b.allow() {
+			t.Fatalf("rejected before MinRequests")
+		}
+		b.done(errLoad, 0)
+	}
+	b.allow()
+	b.done(nil, 0)
+	if b.state != breakerOpen {
+		t.Fatalf("state = %d after 3 of 4 failed, want open", b.state)
+	}
+	if b.allow() {
+		t.Fatalf("open breaker allowed a call")
+	}
+
+	// OpenTimeout后进入半开 只放行一个探测调用 成功后恢复
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow() {
+		t.Fatalf("half-open breaker rejected the probe")
+	}
+	if b.allow() {
+		t.Fatalf("half-open breaker allowed more than HalfOpenProbes calls")
+	}
+	b.done(nil, 0)
+	if b.state != breakerClosed || !b.allow() {
+		t.Fatalf("state = %d after a successful probe, want closed", b.state)
+	}
+}
+
+func TestBreakerProbeFails(t *testing.T) {
+	b := &breaker{config: BreakerConfig{MinRequests: 1, OpenTimeout: 20 * time.Millisecond, HalfOpenProbes: 2}.withDefaults()}
+	b.allow()
+	b.done(errLoad, 0)
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow() || !b.allow() {
+		t.Fatalf("half-open breaker rejected the probes")
+	}
+	if b.allow() {
+		t.Fatalf("half-open breaker allowed more than HalfOpenProbes calls")
+	}
+	b.done(nil, 0)
+	if b.state != breakerHalfOpen {
+		t.Fatalf("state = %d after 1 of 2 probes, want half-open", b.state)
+	}
+	// 任何一个探测失败都重新熔断
+	b.done(errLoad, 0)
+	if b.state != breakerOpen || b.allow() {
+		t.Fatalf("state = %d after a failed probe, want open", b.state)
+	}
+}
+
+func TestBreakerSlowCall(t *testing.T) {
+	b := &breaker{config: BreakerConfig{MinRequests: 2, SlowCall: 10 * time.Millisecond}.withDefaults()}
+	b.allow()
+	b.done(nil, 5*time.Millisecond)
+	b.allow()
+	b.done(nil, 20*time.Millisecond)
+	if b.state != breakerOpen {
+		t.Fatalf("state = %d after half of the calls were slow, want open", b.state)
+	}
+}
+
+func TestBreakerIgnoresCanceled(t *testing.T) {
+	b := &breaker{config: BreakerConfig{MinRequests: 1}.withDefaults()}
+	b.allow()
+	b.done(context.Canceled, 0)
+	if b.state != breakerClosed {
+		t.Fatalf("a canceled call tripped the breaker")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := &rateLimiter{rate: 100, burst: 2, tokens: 2}
+	if !l.allow() || !l.allow() {
+		t.Fatalf("rejected within burst")
+	}
+	if l.allow() {
+		t.Fatalf("allowed beyond burst")
+	}
+	// 每秒补充100个令牌 20ms后至少补充1个 但不超过burst
+	time.Sleep(20 * time.Millisecond)
+	if !l.allow() {
+		t.Fatalf("token was not refilled")
+	}
+	time.Sleep(100 * time.Millisecond)
+	n := 0
+	for l.allow() {
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("%d tokens after a long idle, want burst 2", n)
+	}
+}
+
+func TestMaxConcurrentLoads(t *testing.T) {
+	release := make(chan struct{})
+	g := newGroup("guard", 0, RetrieverFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(key), nil
+	}), WithMaxConcurrentLoads(1))
+	defer g.close()
+
+	done := make(chan struct{})
+	go func() {
+		g.Get("a")
+		close(done)
+	}()
+	for len(g.loadSem) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := g.Get("b"); !errors.Is(err, ErrLoadRejected) {
+		t.Fatalf("err = %v, want ErrLoadRejected", err)
+	}
+	close(release)
+	<-done
+	if view, err := g.Get("b"); err != nil || view.String() != "b" {
+		t.Fatalf("Get = %q, %v after the load finished", view.String(), err)
+	}
+
+	// n <= 0 时不限制
+	g = newGroup("guard", 0, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithMaxConcurrentLoads(0), WithLoadRateLimit(0, 0))
+	defer g.close()
+	if g.loadSem != nil || g.limiter != nil {
+		t.Fatalf("non-positive limits were not treated as unlimited")
+	}
+}
+
+// TestStaleOnReject 加载被拒绝时返回已过期的旧值
+func TestStaleOnReject(t *testing.T) {
+	g := newGroup("guard", 0, RetrieverFunc(func(key string) ([]byte, error) {
+		return []byte("v1"), nil
+	}), WithTTL(10*time.Millisecond), WithStaleCache(1<<10), WithLoadRateLimit(0.001, 1))
+	defer g.close()
+
+	if view, err := g.Get("key"); err != nil || view.Stale() {
+		t.Fatalf("Get = %q, %v, stale %v", view.String(), err, view.Stale())
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	view, err := g.Get("key")
+	if err != nil || view.String() != "v1" || !view.Stale() {
+		t.Fatalf("Get = %q, %v, stale %v, want the stale value", view.String(), err, view.Stale())
+	}
+	if _, err := g.Get("other"); !errors.Is(err, ErrLoadRejected) {
+		t.Fatalf("err = %v without a stale value, want ErrLoadRejected", err)
+	}
+	if g.stats.loadRejects.Load() != 2 || g.stats.staleServed.Load() != 1 {
+		t.Fatalf("rejects %d, stale served %d", g.stats.loadRejects.Load(), g.stats.staleServed.Load())
+	}
+}
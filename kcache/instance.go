@@ -0,0 +1,147 @@
+package kcache
+
+import (
+	"log"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// instance 模块使多个互不干扰的kcache节点可以运行在同一进程中
+// 包级别的 NewGroup/GetGroup/DestroyGroup 都作用于 defaultInstance
+
+const defaultService = "kcache"
+
+// Config 是一个Instance的配置 零值字段使用默认值
+type Config struct {
+	Addr     string          // 本节点的地址 format: ip:port 为空时不创建Server 只在本地缓存
+	Service  string          // 注册至etcd的服务名 同一集群的节点必须相同 默认为kcache
+	Etcd     clientv3.Config // 连接etcd的配置 Endpoints为空时连接localhost:2379
+	Replicas int             // 一致性哈希中每个节点的虚拟节点个数 默认为50
+}
+
+// withDefaults 返回填充了默认值的配置
+func (c Config) withDefaults() Config {
+	if c.Service == "" {
+		c.Service = defaultService
+	}
+	if len(c.Etcd.Endpoints) == 0 {
+		c.Etcd = defaultEtcdConfig
+	}
+	if c.Replicas <= 0 {
+		c.Replicas = defaultReplicas
+	}
+	return c
+}
+
+// Instance 是一个kcache节点 持有自己的Group、Server(及其哈希环)与配置
+type Instance struct {
+	config Config
+
+	mu     sync.RWMutex
+	groups map[string]*Group
+	server *Server // Config.Addr为空时为nil
+}
+
+var defaultInstance = newInstance(Config{})
+
+func newInstance(config Config) *Instance {
+	return &Instance{
+		config: config.withDefaults(),
+		groups: make(map[string]*Group),
+	}
+}
+
+// NewInstance 根据config创建一个kcache节点
+// Config.Addr不为空时同时创建该节点的Server 需调用Start后才开始服务
+func NewInstance(config Config) (*Instance, error) {
+	inst := newInstance(config)
+	if inst.config.Addr != "" {
+		svr, err := newServer(inst, inst.config.Addr)
+		if err != nil {
+			return nil, err
+		}
+		inst.server = svr
+	}
+	return inst, nil
+}
+
+// Server 返回Instance的Server 没有时返回nil
+func (inst *Instance) Server() *Server {
+	return inst.server
+}
+
+// Start 启动Instance的Server 服务就绪后返回 没有Server时是一个no-op
+func (inst *Instance) Start() error {
+	if inst.server == nil {
+		return nil
+	}
+	return inst.server.Start()
+}
+
+// NewGroup 在Instance中创建一个新的缓存空间
+// 未通过 WithPicker 指定时 Group挂载至Instance的Server
+func (inst *Instance) NewGroup(name string, maxBytes int64, retriever Retriever, opts ...GroupOption) *Group {
+	g := newGroup(name, maxBytes, retriever, opts...)
+	if g.server == nil && inst.server != nil {
+		g.server = inst.server
+	}
+
+	inst.mu.Lock()
+	inst.groups[name] = g
+	inst.mu.Unlock()
+
+	return g
+}
+
+// GetGroup 获取Instance中对应命名空间的缓存
+func (inst *Instance) GetGroup(name string) *Group {
+	inst.mu.RLock()
+	g := inst.groups[name]
+	inst.mu.RUnlock()
+	return g
+}
+
+// DestroyGroup 关闭并移除Instance中对应命名空间的缓存
+// Group挂载的Server可能仍被其他Group使用 因此不会被停止
+func (inst *Instance) DestroyGroup(name string) {
+	inst.mu.Lock()
+	g := inst.groups[name]
+	delete(inst.groups, name)
+	inst.mu.Unlock()
+
+	if g != nil {
+		g.close()
+		log.Printf("Destroy cache [%s]", name)
+	}
+}
+
+// Close 停止Instance的Server 并关闭其中全部的Group
+func (inst *Instance) Close() {
+	if inst.server != nil {
+		inst.server.Stop()
+	}
+
+	inst.mu.Lock()
+	groups := inst.groups
+	inst.groups = make(map[string]*Group)
+	inst.mu.Unlock()
+
+	for _, g := range groups {
+		g.close()
+	}
+}
+
+// servedBy 返回Instance中挂载至s的全部Group
+func (inst *Instance) servedBy(s *Server) []*Group {
+	inst.mu.RLock()
+	defer inst.mu.RUnlock()
+
+	served := make([]*Group, 0, len(inst.groups))
+	for _, g := range inst.groups {
+		if g.server == Picker(s) {
+			served = append(served, g)
+		}
+	}
+	return served
+}
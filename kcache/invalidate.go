@@ -0,0 +1,85 @@
+package kcache
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// invalidate 模块实现按标签或前缀批量删除缓存
+// 本节点删除自己cache与hotcache中匹配的key后 并行广播至其余全部节点
+// 因为匹配的key可能由任意节点负责 任何一个节点失败都会返回error
+
+// InvalidateTag 删除整个集群中带有tag的全部缓存 返回删除的key的总数
+// 标签在加载时由 Result.Tags 设置 或在写入时由 TaggedWith 设置
+func (g *Group) InvalidateTag(tag string) (int, error) {
+	if tag == "" {
+		return 0, fmt.Errorf("tag required")
+	}
+	return g.invalidate(tag, "")
+}
+
+// InvalidatePrefix 删除整个集群中以prefix开头的全部缓存 返回删除的key的总数
+func (g *Group) InvalidatePrefix(prefix string) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("prefix required")
+	}
+	return g.invalidate("", prefix)
+}
+
+func (g *Group) invalidate(tag string, prefix string) (int, error) {
+	removed, _ := g.invalidateLocally(tag, prefix)
+	if g.server == nil {
+		return removed, nil
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	for _, peer := range g.server.Peers() {
+		invalidator, ok := peer.(Invalidator)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := invalidator.Invalidate(g.name, tag, prefix)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("fail to invalidate on peer, %s.\n", err.Error())
+				errs = append(errs, err)
+				return
+			}
+			removed += n
+		}()
+	}
+	wg.Wait()
+	return removed, errors.Join(errs...)
+}
+
+// invalidateLocally 删除本节点cache与hotcache中带有tag或以prefix开头的key 返回删除的个数
+// 匹配的计数器一并删除
+func (g *Group) invalidateLocally(tag string, prefix string) (int, error) {
+	switch {
+	case tag != "" && prefix != "":
+		return 0, fmt.Errorf("only one of tag and prefix can be set")
+	case tag != "":
+		if g.stale != nil {
+			g.stale.removeTag(tag)
+		}
+		g.counters.removeTag(tag)
+		return g.cache.removeTag(tag) + g.hotcache.removeTag(tag), nil
+	case prefix != "":
+		if g.stale != nil {
+			g.stale.removePrefix(prefix)
+		}
+		g.counters.removePrefix(prefix)
+		return g.cache.removePrefix(prefix) + g.hotcache.removePrefix(prefix), nil
+	}
+	return 0, fmt.Errorf("tag or prefix required")
+}
@@ -1,49 +1,207 @@
 package kcache
 
 import (
+	"context"
 	"fmt"
 	"kcache/kcache/singleflight"
+	"kcache/kcache/slab"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-)
-
+// Retriever 缓存未命中时 从数据源取回key对应的数据
+// 调用者取消或超时后 应尽快放弃数据源的访问并返回ctx.Err()
 type Retriever interface {
-	retrieve(string) ([]byte, error)
+	Retrieve(ctx context.Context, key string) ([]byte, error)
+}
+
+// BatchRetriever 是可选的接口 Retriever同时实现它时
+// GetMulti 会将未命中的多个key合并为一次RetrieveMulti 例如一条 WHERE id IN (...) 查询
+// errs 记录取回失败的key 返回的error代表整个调用失败 两者都没有的key视为不存在
+type BatchRetriever interface {
+	Retriever
+	RetrieveMulti(ctx context.Context, keys []string) (values map[string][]byte, errs map[string]error, err error)
 }
 
 type RetrieverFunc func(key string) ([]byte, error)
 
-// RetrieverFunc 通过实现retrieve方法，使得任意匿名函数func
+// RetrieverFunc 通过实现Retrieve方法，使得任意匿名函数func
 // 通过被RetrieverFunc(func)类型强制转换后，实现了 Retriever 接口的能力
-func (f RetrieverFunc) retrieve(key string) ([]byte, error) {
+func (f RetrieverFunc) Retrieve(_ context.Context, key string) ([]byte, error) {
 	return f(key)
 }
 
+// RetrieverContextFunc 与 RetrieverFunc 相同 但可以拿到调用者的ctx
+type RetrieverContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f RetrieverContextFunc) Retrieve(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Result 是取回的一条数据及其缓存方式
+// 由Retriever返回时 TTL为0代表使用Group的默认过期时间
+// 由Fetcher返回时 TTL是远端记录剩余的过期时间 0代表永不过期
+type Result struct {
+	Value   []byte
+	TTL     time.Duration // 过期时间 使不同的记录可以有不同的新鲜度要求
+	ETag    string        // 数据的版本 随值一起缓存 并通过ByteView.ETag返回给调用者
+	NoCache bool          // 为true时只返回给调用者 不写入cache与hotcache
+	Tags    []string      // 标签 之后可以通过 Group.InvalidateTag 批量删除带有某个标签的记录
+	Version uint64        // 由Fetcher返回时为记录在负责节点上的版本 Retriever返回的Version会被忽略
+}
+
+// ResultRetriever 是可选的接口 Retriever同时实现它时
+// getLocally 使用RetrieveResult取回数据 并按Result中的元数据缓存
+type ResultRetriever interface {
+	Retriever
+	RetrieveResult(ctx context.Context, key string) (Result, error)
+}
+
+// ResultRetrieverFunc 将返回Result的函数转换为 ResultRetriever
+type ResultRetrieverFunc func(ctx context.Context, key string) (Result, error)
+
+func (f ResultRetrieverFunc) Retrieve(ctx context.Context, key string) ([]byte, error) {
+	r, err := f(ctx, key)
+	return r.Value, err
+}
+
+func (f ResultRetrieverFunc) RetrieveResult(ctx context.Context, key string) (Result, error) {
+	return f(ctx, key)
+}
+
+// BatchResultRetriever 是可选的接口 与 BatchRetriever 相同 但每条数据带有 Result 中的元数据
+// 实现了 ResultRetriever 的Retriever 只有同时实现它时 GetMulti 才会批量加载 以免丢失元数据
+type BatchResultRetriever interface {
+	Retriever
+	RetrieveMultiResult(ctx context.Context, keys []string) (values map[string]Result, errs map[string]error, err error)
+}
+
 // Group 提供命名管理缓存/填充缓存的能力
 type Group struct {
 	name      string
 	cache     *cache
 	hotcache  *cache
 	retriever Retriever
+	writer    Writer       // 不为nil时Set同步写入数据源(write-through)
+	behind    *writeBehind // 不为nil时Set异步批量写入数据源(write-behind)
 	server    Picker
 	flight    *singleflight.Flight
+	ttl       time.Duration // 缓存记录的过期时间 0代表永不过期
+	pool      *slab.Pool    // cache与hotcache共用的内存池 为nil时不使用内存池
+	decoded   *decodedCache // GetTyped解码后的对象 为nil时每次都解码
+	versions  atomic.Uint64 // 最近一次分配的版本
+	locks     [lockStripes]sync.Mutex
+	fillLease time.Duration // 加载lease的有效期 为0时不在集群内协调加载
+	leases    leaseTable    // 本节点负责的key上的加载lease
+	counters  counterTable  // 本节点负责的计数器 不受cache容量限制
+	breaker   *breaker      // Retriever的熔断器 为nil时不熔断
+	loadSem   chan struct{} // 限制同时调用Retriever的个数 为nil时不限制
+	limiter   *rateLimiter  // 限制每秒调用Retriever的次数 为nil时不限制
+	stale     *cache        // cache中已过期的记录 加载被拒绝时返回 为nil时不保留
+	stats     groupStats
 }
 
-// NewGroup 创建一个新的缓存空间
-func NewGroup(addr string, name string, maxBytes int64, retriever Retriever) *Group {
-	if retriever == nil {
-		panic("Group retriever must be existed!")
+// GroupOption 用于在创建Group时定制其行为
+type GroupOption func(*Group)
+
+// WithTTL 设置Group中缓存记录的过期时间
+// 由getLocally填充至cache 以及从peer取回填充至hotcache的记录 均在ttl后过期
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
 	}
+}
 
-	svr, err := NewServer(addr)
-	if err != nil {
-		log.Fatal(err)
+// WithPicker 将Group挂载至p(通常是一个 *Server) 未命中的key经p路由至负责的节点
+// 一个Server可以被任意多个Group共用 不使用该选项时Group只在本地缓存
+// Server只响应与它属于同一Instance的Group 远端节点上同名的Group才能被访问到
+func WithPicker(p Picker) GroupOption {
+	return func(g *Group) {
+		g.server = p
+	}
+}
+
+// WithLRUK 令Group的cache使用LRU-K算法淘汰缓存
+// 只被访问过一次的key(如批量任务的一次性扫描)不会挤出已被访问k次的热点数据
+func WithLRUK(k int) GroupOption {
+	return WithPolicy(LRUKPolicy(k))
+}
+
+// WithPolicy 设置Group的cache所使用的淘汰策略 默认为LRU
+func WithPolicy(p Policy) GroupOption {
+	return func(g *Group) {
+		g.cache.policy = p
+	}
+}
+
+// WithHotPolicy 设置Group的hotcache所使用的淘汰策略 默认为LRU
+func WithHotPolicy(p Policy) GroupOption {
+	return func(g *Group) {
+		g.hotcache.policy = p
+	}
+}
+
+// WithShards 设置Group的cache与hotcache的分片数
+// 默认根据容量自动决定 最多16个分片
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.cache.shardNum = n
+		g.hotcache.shardNum = n
+	}
+}
+
+// WithMemoryPool 令Group的cache与hotcache将数据存放在按大小分级的内存池中
+// 被淘汰的数据所占的内存归还内存池复用 以降低大量小记录带来的GC压力
+// 代价是每次命中都要从内存池复制出一份交给调用者 适合缓存记录多、命中相对少的场景
+// 读多写少的热点数据不开启内存池时 命中没有额外的分配
+// 开启后Retriever返回的[]byte将直接交给调用者 Retriever不应再修改它
+func WithMemoryPool() GroupOption {
+	return func(g *Group) {
+		g.pool = slab.New()
+		g.cache.pool = g.pool
+		g.hotcache.pool = g.pool
+	}
+}
+
+// WithEntryOverhead 令Group的cache与hotcache在统计容量时 为每条记录额外计入overhead字节
+// 使maxBytes更接近实际占用的内存 overhead 可使用估算值 EntryOverhead
+// 或由 CalibrateOverhead 在当前平台上实测
+func WithEntryOverhead(overhead int64) GroupOption {
+	return func(g *Group) {
+		g.cache.overhead = overhead
+		g.hotcache.overhead = overhead
+	}
+}
+
+// WithOnEvicted 设置Group的cache与hotcache中记录被移除时的回调
+// 回调在释放cache的锁之后执行 可以在其中访问Group
+func WithOnEvicted(fn OnEvicted) GroupOption {
+	return func(g *Group) {
+		g.cache.onEvicted = fn
+		g.hotcache.onEvicted = fn
+	}
+}
+
+// MemoryPoolStats 返回内存池的使用情况与碎片率 未开启内存池时返回零值
+func (g *Group) MemoryPoolStats() slab.Stats {
+	if g.pool == nil {
+		return slab.Stats{}
+	}
+	return g.pool.Stats()
+}
+
+// NewGroup 在默认的Instance中创建一个新的缓存空间
+// 默认只在本地缓存 通过 WithPicker 挂载至Server后才与其他节点协作
+// Server的启动与停止由调用者负责 与Group的生命周期无关
+func NewGroup(name string, maxBytes int64, retriever Retriever, opts ...GroupOption) *Group {
+	return defaultInstance.NewGroup(name, maxBytes, retriever, opts...)
+}
+
+func newGroup(name string, maxBytes int64, retriever Retriever, opts ...GroupOption) *Group {
+	if retriever == nil {
+		panic("Group retriever must be existed!")
 	}
 
 	g := &Group{
@@ -52,24 +210,11 @@ func NewGroup(addr string, name string, maxBytes int64, retriever Retriever) *Gr
 		hotcache:  newCache(maxBytes / 10),
 		retriever: retriever,
 		flight:    &singleflight.Flight{},
-		server:    svr, // 将服务与cache绑定 因为cache和server是解耦合的
+		fillLease: defaultFillLease,
+	}
+	for _, opt := range opts {
+		opt(g)
 	}
-
-	mu.Lock()
-	groups[name] = g
-	mu.Unlock()
-
-	// 启动服务(注册服务至etcd/计算一致性哈希...)
-	go func() {
-		// Start将不会return 除非服务stop或者抛出error
-		err = svr.Start()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	time.Sleep(100 * time.Millisecond)
-
 	return g
 }
 
@@ -80,64 +225,293 @@ func (g *Group) RegisterSvr(p Picker) {
 	g.server = p
 }
 
-// GetGroup 获取对应命名空间的缓存
+// GetGroup 获取默认Instance中对应命名空间的缓存
 func GetGroup(name string) *Group {
-	mu.RLock()
-	g := groups[name]
-	mu.RUnlock()
-	return g
+	return defaultInstance.GetGroup(name)
 }
 
+// DestroyGroup 关闭并移除默认Instance中对应命名空间的缓存
 func DestroyGroup(name string) {
-	g := GetGroup(name)
-	if g != nil {
-		svr := g.server.(*Server)
-		svr.Stop()
-		delete(groups, name)
-		log.Printf("Destroy cache [%s %s]", name, svr.addr)
+	defaultInstance.DestroyGroup(name)
+}
+
+// close 写完write-behind中剩余的数据 并停止cache与hotcache的后台清理
+// Group关闭后不应再被使用
+func (g *Group) close() {
+	if g.behind != nil {
+		g.behind.close()
+	}
+	g.cache.close()
+	g.hotcache.close()
+	g.counters.close()
+	if g.stale != nil {
+		g.stale.close()
+	}
+}
+
+// purgeMoved 节点变化后 移除cache中已改由其他节点负责的key
+// 之后对这些key的读写都将路由至新的节点 留在本地的副本会逐渐过时
+func (g *Group) purgeMoved() {
+	svr, ok := g.server.(*Server)
+	if !ok {
+		return
+	}
+	n := g.cache.removeFunc(func(key string) bool {
+		return !svr.owns(key)
+	}, EvictOwnershipMoved)
+	if n > 0 {
+		log.Printf("[%s] purge %d keys owned by other peers", g.name, n)
+	}
+	// 计数器不随cache一起丢弃 累加至新的负责节点 需要rpc 因此不阻塞节点的更新
+	go g.handOffCounters(svr)
+	// 改由本节点负责的key 其hotcache中的副本可能已过时
+	n = g.hotcache.removeFunc(svr.owns, EvictOwnershipMoved)
+	if n > 0 {
+		log.Printf("[%s] purge %d hot keys now owned by this peer", g.name, n)
 	}
 }
 
 // 先看本地缓存
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 与 Get 相同 ctx的截止时间与取消会传递给远端节点与Retriever
+// ctx通过 WithRequestID 携带的请求ID也会随rpc传递至远端节点
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	log.Printf("Get " + key)
 
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key required")
 	}
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, err
+	}
+	g.stats.gets.Add(1)
 	if value, ok := g.cache.get(key); ok {
 		log.Println("cache hit")
+		g.stats.cacheHits.Add(1)
 		return value, nil
 	}
 	if value, ok := g.hotcache.get(key); ok {
 		log.Println("hot cache hit")
+		g.stats.hotCacheHits.Add(1)
 		return value, nil
 	}
 
 	log.Println("local cache missing, get it from remote")
 
-	return g.load(key)
+	return g.load(ctx, key)
+}
+
+// Remove 删除key对应的缓存
+// 先删除本地的cache与hotcache 再将删除转发至负责该key的节点
+// 最后广播至其余节点 使它们hotcache中的副本失效
+// 只有转发至负责节点失败时返回error 广播失败仅记录日志
+func (g *Group) Remove(key string) error {
+	if key == "" {
+		return fmt.Errorf("key required")
+	}
+	g.removeLocally(key)
+	if g.server == nil {
+		return nil
+	}
+
+	var err error
+	owner, remote := g.server.Pick(key)
+	if remote {
+		if deleter, ok := owner.(Deleter); ok {
+			err = deleter.Delete(g.name, key)
+		}
+	}
+	g.invalidatePeers(key, owner)
+	return err
+}
+
+// invalidatePeers 广播至除skip外的其余节点 删除它们持有的key的副本
+// 广播失败仅记录日志
+func (g *Group) invalidatePeers(key string, skip Fetcher) {
+	for _, peer := range g.server.Peers() {
+		if skip != nil && peer == skip {
+			continue
+		}
+		if deleter, ok := peer.(Deleter); ok {
+			if err := deleter.Delete(g.name, key); err != nil {
+				log.Printf("fail to invalidate *%s* on peer, %s.\n", key, err.Error())
+			}
+		}
+	}
+}
+
+// SetOption 用于定制 Set 的行为
+type SetOption func(*setOptions)
+
+type setOptions struct {
+	ttl  time.Duration
+	tags []string
+}
+
+// ExpireAfter 设置写入的记录在ttl后过期 默认使用Group的过期时间
+func ExpireAfter(ttl time.Duration) SetOption {
+	return func(o *setOptions) {
+		o.ttl = ttl
+	}
+}
+
+// TaggedWith 为写入的记录设置标签 之后可以通过 Group.InvalidateTag 批量删除
+func TaggedWith(tags ...string) SetOption {
+	return func(o *setOptions) {
+		o.tags = tags
+	}
+}
+
+// Set 写入key对应的值
+// 写入经一致性哈希路由至负责该key的节点 存放在其cache中
+// 配置了Writer时由负责节点在key的锁内先将值交给数据源 见 WithWriteThrough 与 WithWriteBehind
+// 之后广播至其余节点 删除它们hotcache中的旧副本
+func (g *Group) Set(key string, value []byte, opts ...SetOption) error {
+	if key == "" {
+		return fmt.Errorf("key required")
+	}
+	o := setOptions{ttl: g.ttl}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ctx := context.Background()
+
+	if g.server == nil {
+		return g.setLocally(ctx, key, value, o.ttl, o.tags)
+	}
+
+	owner, remote := g.server.Pick(key)
+	if !remote {
+		if err := g.setLocally(ctx, key, value, o.ttl, o.tags); err != nil {
+			return err
+		}
+		g.invalidatePeers(key, nil)
+		return nil
+	}
+
+	setter, ok := owner.(Setter)
+	if !ok {
+		return fmt.Errorf("peer of *%s* does not support set", key)
+	}
+	if err := setter.Set(g.name, key, value, o.ttl, o.tags...); err != nil {
+		return err
+	}
+	// 本节点不负责该key 删除本地可能残留的旧副本
+	g.removeLocally(key)
+	g.invalidatePeers(key, owner)
+	return nil
+}
+
+// setLocally 将key对应的值写入本节点的cache 并删除hotcache中的旧副本
+// 配置了Writer时先将值交给数据源 与写入cache在同一把key的锁内 使数据源与cache中的写入顺序一致
+func (g *Group) setLocally(ctx context.Context, key string, value []byte, ttl time.Duration, tags []string) error {
+	mu := g.lockKey(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := g.persist(ctx, key, value); err != nil {
+		return err
+	}
+	// 之后的Incr以写入的值为初始值
+	g.counters.remove(key)
+	g.hotcache.remove(key)
+	g.populateCache(key, ByteView{b: value, tags: tags}, ttl)
+	// 值已写入 等待加载lease的请求可以直接使用它
+	g.leases.release(key, 0)
+	return nil
+}
+
+// populateCache 将数据填充至cache 并为其分配新的版本 返回可交给调用者的ByteView
+// 调用时需持有key的锁
+func (g *Group) populateCache(key string, value ByteView, ttl time.Duration) ByteView {
+	// 使用内存池时cache会自行复制一份 无需再复制
+	if g.pool == nil {
+		value.b = cloneBytes(value.b)
+	}
+	if ttl > 0 {
+		value.expire = time.Now().Add(ttl)
+	}
+	value.version = g.nextVersion()
+
+	g.cache.addWithTTL(key, value, ttl)
+	return value
+}
+
+// fillCache 将从数据源加载的数据填充至cache
+// 加载期间key若已被Set或CompareAndSwap写入 则保留写入的值并返回它
+func (g *Group) fillCache(key string, value ByteView, ttl time.Duration) ByteView {
+	mu := g.lockKey(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if cur, ok := g.cache.lookup(key); ok {
+		return cur
+	}
+	return g.populateCache(key, value, ttl)
+}
+
+// populateHotCache 将从远端节点取回的数据填充至hotcache 返回可交给调用者的ByteView
+// 副本的过期时间不超过远端记录剩余的过期时间 也不超过Group的过期时间
+func (g *Group) populateHotCache(key string, r Result) ByteView {
+	value := ByteView{b: r.Value, etag: r.ETag, noCache: r.NoCache, tags: r.Tags, version: r.Version}
+	if r.NoCache {
+		return value
+	}
+
+	ttl := r.TTL
+	if ttl == 0 || (g.ttl > 0 && g.ttl < ttl) {
+		ttl = g.ttl
+	}
+	if ttl > 0 {
+		value.expire = time.Now().Add(ttl)
+	}
+	g.hotcache.addWithTTL(key, value, ttl)
+	return value
+}
+
+// removeLocally 删除本地cache与hotcache中key对应的缓存 返回key是否存在
+func (g *Group) removeLocally(key string) bool {
+	inCache := g.cache.remove(key)
+	inHot := g.hotcache.remove(key)
+	inCounters := g.counters.remove(key)
+	if g.stale != nil {
+		// 被删除的key不应再以旧值返回
+		g.stale.remove(key)
+	}
+	return inCache || inHot || inCounters
 }
 
 // 从peer获取
-func (g *Group) load(key string) (ByteView, error) {
-	view, err := g.flight.Fly(key, func() (interface{}, error) {
+// 同一key的并发请求只会加载一次 加载不随其中某一个请求的ctx取消
+// 各请求的ctx被取消时各自提前返回 全部请求都放弃后才中断加载
+func (g *Group) load(ctx context.Context, key string) (ByteView, error) {
+	view, err, shared := g.flight.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
 		if g.server != nil {
 			if fetcher, ok := g.server.Pick(key); ok {
-				bytes, err := fetcher.Fetch(g.name, key)
+				r, err := fetcher.Fetch(ctx, g.name, key)
 				if err == nil {
-					//return ByteView{b: cloneBytes(bytes)}, nil
-					g.hotcache.add(key, ByteView{b: bytes})
-					return ByteView{b: bytes}, nil
+					g.stats.peerLoads.Add(1)
+					return g.populateHotCache(key, r), nil
 				}
+				g.stats.peerErrors.Add(1)
 				log.Printf("fail to get *%s* from peer, %s.\n", key, err.Error())
+				// 调用者都已放弃 无需再回退至本地加载
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				return g.fillFromPeer(ctx, fetcher, key)
 			}
-		} else {
-			fmt.Print("g.server == nil\n")
+			return g.fillLocally(ctx, key)
 		}
 
-		return g.getLocally(key)
+		return g.getLocally(ctx, key)
 	})
+	if shared {
+		g.stats.flightDedups.Add(1)
+	}
 	if err == nil {
 		return view.(ByteView), err
 	}
@@ -145,16 +519,51 @@ func (g *Group) load(key string) (ByteView, error) {
 }
 
 // 本地向Retriever取回数据并填充缓存
-func (g *Group) getLocally(key string) (ByteView, error) {
+// key是计数器时从计数器表恢复 不访问Retriever
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	if view, ok := g.loadCounter(key); ok {
+		return view, nil
+	}
+	done, err := g.admit()
+	if err != nil {
+		return g.reject(key, err)
+	}
 	log.Printf("Get from retriever")
 
-	bytes, err := g.retriever.retrieve(key)
+	g.stats.localLoads.Add(1)
+	start := time.Now()
+	r, err := g.retrieve(ctx, key)
+	done(err, time.Since(start))
 	if err != nil {
+		g.stats.localLoadErrs.Add(1)
 		return ByteView{}, err
 	}
 
-	value := ByteView{b: cloneBytes(bytes)}
+	return g.fillResult(key, r), nil
+}
 
-	g.cache.add(key, value)
-	return value, nil
+// fillResult 按Result中的元数据填充cache NoCache的数据只返回给调用者
+func (g *Group) fillResult(key string, r Result) ByteView {
+	value := ByteView{b: r.Value, etag: r.ETag, tags: r.Tags}
+	if r.NoCache {
+		value.noCache = true
+		if g.pool == nil {
+			value.b = cloneBytes(value.b)
+		}
+		return value
+	}
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = g.ttl
+	}
+	return g.fillCache(key, value, ttl)
+}
+
+// retrieve 向Retriever取回数据 Retriever实现了ResultRetriever时带有元数据
+func (g *Group) retrieve(ctx context.Context, key string) (Result, error) {
+	if rr, ok := g.retriever.(ResultRetriever); ok {
+		return rr.RetrieveResult(ctx, key)
+	}
+	bytes, err := g.retriever.Retrieve(ctx, key)
+	return Result{Value: bytes}, err
 }
@@ -0,0 +1,160 @@
+package lru
+
+import "time"
+
+// ARC 是自适应替换缓存(Adaptive Replacement Cache)算法实现的缓存
+// t1 存放只被访问过一次的记录 t2 存放被访问过多次的记录
+// b1/b2 分别是t1/t2的幽灵队列 只记录被淘汰的key
+// 命中幽灵队列时动态调整t1的目标容量p 使缓存在近期性与频繁性之间自适应
+// 原始ARC按记录个数计算容量 这里按字节计算
+type ARC struct {
+	capacity int64 // Cache 最大容量(Byte)
+	p        int64 // t1 的目标容量(Byte)
+	t1, t2   *Cache
+	b1, b2   *Cache
+
+	callback OnEliminated
+}
+
+// NewARC 创建指定最大容量的ARC缓存。
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func NewARC(maxBytes int64, callback OnEliminated) *ARC {
+	// t1/t2 的容量由ARC统一管理 幽灵队列各自最多记录maxBytes的数据
+	return &ARC{
+		capacity: maxBytes,
+		t1:       New(0, callback),
+		t2:       New(0, callback),
+		b1:       New(maxBytes, nil),
+		b2:       New(maxBytes, nil),
+		callback: callback,
+	}
+}
+
+// SetDefaultTTL 设置 Add 写入时使用的默认过期时间
+func (c *ARC) SetDefaultTTL(ttl time.Duration) {
+	c.t1.SetDefaultTTL(ttl)
+	c.t2.SetDefaultTTL(ttl)
+}
+
+// SetOnEliminated 设置淘汰回调
+func (c *ARC) SetOnEliminated(callback OnEliminated) {
+	c.callback = callback
+	c.t1.SetOnEliminated(callback)
+	c.t2.SetOnEliminated(callback)
+}
+
+// SetSizer 设置计算记录占用字节数的方法 应在写入数据前设置
+func (c *ARC) SetSizer(sizer Sizer) {
+	c.t1.SetSizer(sizer)
+	c.t2.SetSizer(sizer)
+}
+
+// Len 返回缓存记录个数 不包括幽灵队列
+func (c *ARC) Len() int {
+	return c.t1.Len() + c.t2.Len()
+}
+
+// Bytes 返回缓存当前占用的字节数 不包括幽灵队列
+func (c *ARC) Bytes() int64 {
+	return c.t1.Bytes() + c.t2.Bytes()
+}
+
+// Get 从缓存获取对应key的value 命中t1的记录将晋升至t2
+func (c *ARC) Get(key string) (value Lengthable, ok bool) {
+	if entry, ok := c.t1.peek(key); ok {
+		c.t1.detach(key)
+		c.t2.add(entry.key, entry.value, entry.expire)
+		return entry.value, true
+	}
+	return c.t2.Get(key)
+}
+
+// Add 使用默认过期时间写入缓存
+func (c *ARC) Add(key string, value Lengthable) {
+	c.AddWithTTL(key, value, c.t1.defaultTTL)
+}
+
+// AddWithTTL 写入缓存 并在ttl后过期
+func (c *ARC) AddWithTTL(key string, value Lengthable, ttl time.Duration) {
+	kvSize := c.t1.sizer(key, value)
+	expire := expireAt(ttl)
+
+	// 已缓存的key 更新后置于t2
+	if old, ok := c.t1.detach(key); ok {
+		c.t2.add(key, value, expire)
+		if c.callback != nil {
+			c.callback(key, old.value, ReasonReplaced)
+		}
+		c.reclaim(0, false)
+		return
+	}
+	if _, ok := c.t2.hashmap[key]; ok {
+		c.t2.add(key, value, expire)
+		c.reclaim(0, false)
+		return
+	}
+
+	// 命中b1 说明t1容量偏小 增大p
+	if _, ok := c.b1.detach(key); ok {
+		c.p = min(c.p+max(c.b2.Bytes()/max(c.b1.Bytes(), 1), 1)*kvSize, c.capacity)
+		c.reclaim(kvSize, false)
+		c.t2.add(key, value, expire)
+		return
+	}
+	// 命中b2 说明t2容量偏小 减小p
+	if _, ok := c.b2.detach(key); ok {
+		c.p = max(c.p-max(c.b1.Bytes()/max(c.b2.Bytes(), 1), 1)*kvSize, 0)
+		c.reclaim(kvSize, true)
+		c.t2.add(key, value, expire)
+		return
+	}
+
+	c.reclaim(kvSize, false)
+	c.t1.add(key, value, expire)
+}
+
+// reclaim 按照目标容量p 从t1或t2淘汰记录 直到可以容纳size字节的新记录
+func (c *ARC) reclaim(size int64, inB2 bool) {
+	for c.capacity != 0 && c.Bytes()+size > c.capacity && c.Len() > 0 {
+		t1Bytes := c.t1.Bytes()
+		if t1Bytes > 0 && (t1Bytes > c.p || (inB2 && t1Bytes == c.p) || c.t2.Len() == 0) {
+			c.evict(c.t1, c.b1)
+		} else {
+			c.evict(c.t2, c.b2)
+		}
+	}
+}
+
+// evict 淘汰from的链尾记录 并将其key记入幽灵队列ghosts
+func (c *ARC) evict(from *Cache, ghosts *Cache) {
+	entry := from.detachElement(from.doublyLinkedList.Back())
+	ghosts.add(entry.key, ghost(entry.size), time.Time{})
+	if c.callback != nil {
+		c.callback(entry.key, entry.value, ReasonCapacity)
+	}
+}
+
+// Remove 按照目标容量p淘汰一枚缓存
+func (c *ARC) Remove() {
+	switch {
+	case c.t1.Len() > 0 && (c.t1.Bytes() > c.p || c.t2.Len() == 0):
+		c.evict(c.t1, c.b1)
+	case c.t2.Len() > 0:
+		c.evict(c.t2, c.b2)
+	}
+}
+
+// Delete 删除key对应的缓存 返回key是否存在
+func (c *ARC) Delete(key string) bool {
+	return c.t1.Delete(key) || c.t2.Delete(key)
+}
+
+// Keys 返回所有缓存的key 不包括幽灵队列
+func (c *ARC) Keys() []string {
+	return append(c.t2.Keys(), c.t1.Keys()...)
+}
+
+// RemoveExpired 清除所有已过期的缓存 返回清除的个数
+func (c *ARC) RemoveExpired() int {
+	return c.t1.RemoveExpired() + c.t2.RemoveExpired()
+}
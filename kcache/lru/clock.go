@@ -0,0 +1,208 @@
+package lru
+
+import (
+	"container/list"
+	"sync/atomic"
+	"time"
+)
+
+// clockEntry 是CLOCK环上的节点 referenced 为访问位
+type clockEntry struct {
+	Value
+	referenced atomic.Bool
+}
+
+// Clock 是CLOCK(二次机会)算法实现的缓存
+// 所有记录排成一个环 命中时只原子地置位访问位 不调整环的结构
+// 因此命中可以在读锁下并发执行(见 GetShared)
+// 淘汰时指针沿环转动 清除途经记录的访问位 淘汰第一个访问位为0的记录
+type Clock struct {
+	capacity   int64 // Cache 最大容量(Byte)
+	length     int64 // Cache 当前容量(Byte)
+	hashmap    map[string]*list.Element
+	ring       *list.List    // 链尾的下一个节点视为链头 构成环
+	hand       *list.Element // 时钟指针 指向下一个检查的记录
+	defaultTTL time.Duration
+	sizer      Sizer
+
+	callback OnEliminated
+}
+
+// NewClock 创建指定最大容量的CLOCK缓存。
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func NewClock(maxBytes int64, callback OnEliminated) *Clock {
+	return &Clock{
+		capacity: maxBytes,
+		hashmap:  make(map[string]*list.Element),
+		ring:     list.New(),
+		sizer:    defaultSizer,
+		callback: callback,
+	}
+}
+
+// SetDefaultTTL 设置 Add 写入时使用的默认过期时间
+func (c *Clock) SetDefaultTTL(ttl time.Duration) {
+	c.defaultTTL = ttl
+}
+
+// SetOnEliminated 设置淘汰回调
+func (c *Clock) SetOnEliminated(callback OnEliminated) {
+	c.callback = callback
+}
+
+// SetSizer 设置计算记录占用字节数的方法 应在写入数据前设置
+func (c *Clock) SetSizer(sizer Sizer) {
+	if sizer == nil {
+		sizer = defaultSizer
+	}
+	c.sizer = sizer
+}
+
+// Len 返回缓存记录个数
+func (c *Clock) Len() int {
+	return c.ring.Len()
+}
+
+// Bytes 返回缓存当前占用的字节数
+func (c *Clock) Bytes() int64 {
+	return c.length
+}
+
+// GetShared 从缓存获取对应key的value 只读取数据并原子地置位访问位
+// 可以与其他 GetShared 并发调用 但不能与写操作并发
+// 已过期的key视为不存在 留待 Get 或 RemoveExpired 删除
+func (c *Clock) GetShared(key string) (value Lengthable, ok bool) {
+	if elem, ok := c.hashmap[key]; ok {
+		entry := elem.Value.(*clockEntry)
+		if entry.expired(time.Now()) {
+			return nil, false
+		}
+		entry.referenced.Store(true)
+		return entry.value, true
+	}
+	return
+}
+
+// Get 从缓存获取对应key的value 已过期的key会在此时被惰性删除
+func (c *Clock) Get(key string) (value Lengthable, ok bool) {
+	if elem, ok := c.hashmap[key]; ok {
+		entry := elem.Value.(*clockEntry)
+		if entry.expired(time.Now()) {
+			c.removeElement(elem, ReasonExpired)
+			return nil, false
+		}
+		entry.referenced.Store(true)
+		return entry.value, true
+	}
+	return
+}
+
+// Add 使用默认过期时间写入缓存
+func (c *Clock) Add(key string, value Lengthable) {
+	c.AddWithTTL(key, value, c.defaultTTL)
+}
+
+// AddWithTTL 写入缓存 并在ttl后过期
+// 新记录插入在时钟指针之后 即最后一个被检查的位置
+func (c *Clock) AddWithTTL(key string, value Lengthable, ttl time.Duration) {
+	kvSize := c.sizer(key, value)
+	if elem, ok := c.hashmap[key]; ok {
+		entry := elem.Value.(*clockEntry)
+		c.length += kvSize - entry.size
+		entry.size = kvSize
+		oldValue := entry.value
+		entry.value = value
+		entry.expire = expireAt(ttl)
+		entry.referenced.Store(true)
+		if c.callback != nil {
+			c.callback(key, oldValue, ReasonReplaced)
+		}
+		for c.capacity != 0 && c.length > c.capacity && c.ring.Len() > 1 {
+			c.Remove()
+		}
+		return
+	}
+
+	// cache 容量检查
+	for c.capacity != 0 && c.length+kvSize > c.capacity && c.ring.Len() > 0 {
+		c.Remove()
+	}
+	entry := &clockEntry{Value: Value{key: key, value: value, expire: expireAt(ttl), size: kvSize}}
+	if c.hand == nil {
+		c.hand = c.ring.PushBack(entry)
+		c.hashmap[key] = c.hand
+	} else {
+		c.hashmap[key] = c.ring.InsertBefore(entry, c.hand)
+	}
+	c.length += kvSize
+}
+
+// Remove 转动时钟指针 淘汰第一枚访问位为0的缓存
+func (c *Clock) Remove() {
+	for c.hand != nil {
+		elem := c.hand
+		entry := elem.Value.(*clockEntry)
+		if entry.referenced.CompareAndSwap(true, false) {
+			c.advance()
+			continue
+		}
+		c.removeElement(elem, ReasonCapacity)
+		return
+	}
+}
+
+// Delete 删除key对应的缓存 返回key是否存在
+func (c *Clock) Delete(key string) bool {
+	if elem, ok := c.hashmap[key]; ok {
+		c.removeElement(elem, ReasonDeleted)
+		return true
+	}
+	return false
+}
+
+// Keys 返回所有缓存的key
+func (c *Clock) Keys() []string {
+	keys := make([]string, 0, len(c.hashmap))
+	for key := range c.hashmap {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// RemoveExpired 清除所有已过期的缓存 返回清除的个数
+func (c *Clock) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for elem := c.ring.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*clockEntry).expired(now) {
+			c.removeElement(elem, ReasonExpired)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+// advance 时钟指针前进一格 到达链尾后回到链头
+func (c *Clock) advance() {
+	if c.hand = c.hand.Next(); c.hand == nil {
+		c.hand = c.ring.Front()
+	}
+}
+
+func (c *Clock) removeElement(elem *list.Element, reason Reason) {
+	if elem == c.hand {
+		c.advance()
+		if c.hand == elem {
+			c.hand = nil // 环上只剩这一个节点
+		}
+	}
+	entry := elem.Value.(*clockEntry)
+	delete(c.hashmap, entry.key)
+	c.ring.Remove(elem)
+	c.length -= entry.size
+	if c.callback != nil {
+		c.callback(entry.key, entry.value, reason)
+	}
+}
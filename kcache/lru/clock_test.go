@@ -0,0 +1,81 @@
+package lru
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClockSecondChance(t *testing.T) {
+	var e eliminated
+	clock := NewClock(30, e.callback)
+	clock.Add("key1", String("value"))
+	clock.Add("key2", String("value"))
+	clock.Add("key3", String("value"))
+	clock.Get("key1")
+	clock.Add("key4", String("value"))
+
+	// key1被访问过 获得第二次机会 淘汰key2
+	if len(e.keys) != 1 || e.keys[0] != "key2" {
+		t.Fatalf("evicted %v, want key2", e.keys)
+	}
+	if _, ok := clock.Get("key1"); !ok {
+		t.Fatalf("referenced key1 was evicted")
+	}
+}
+
+func TestClockGetShared(t *testing.T) {
+	clock := NewClock(0, nil)
+	clock.AddWithTTL("key", String("value"), 10*time.Millisecond)
+	if v, ok := clock.GetShared("key"); !ok || string(v.(String)) != "value" {
+		t.Fatalf("GetShared missed key")
+	}
+	time.Sleep(20 * time.Millisecond)
+	// GetShared不删除过期的记录 留给写操作处理
+	if _, ok := clock.GetShared("key"); ok || clock.Len() != 1 {
+		t.Fatalf("expired key should be hidden but kept")
+	}
+	if clock.RemoveExpired() != 1 || clock.Bytes() != 0 {
+		t.Fatalf("RemoveExpired failed")
+	}
+}
+
+// BenchmarkGetParallel 比较并发读时CLOCK与LRU的吞吐
+// 锁的使用方式与cache分片相同: CLOCK命中只需读锁 LRU命中需要互斥锁
+func BenchmarkGetParallel(b *testing.B) {
+	const n = 1 << 14
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+
+	b.Run("Clock", func(b *testing.B) {
+		var mu sync.RWMutex
+		clock := NewClock(0, nil)
+		for _, key := range keys {
+			clock.Add(key, String("value"))
+		}
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				mu.RLock()
+				clock.GetShared(keys[i%n])
+				mu.RUnlock()
+			}
+		})
+	})
+	b.Run("LRU", func(b *testing.B) {
+		var mu sync.Mutex
+		lru := New(0, nil)
+		for _, key := range keys {
+			lru.Add(key, String("value"))
+		}
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				mu.Lock()
+				lru.Get(keys[i%n])
+				mu.Unlock()
+			}
+		})
+	})
+}
@@ -0,0 +1,201 @@
+package lru
+
+import (
+	"container/list"
+	"time"
+)
+
+// LFU 是最不经常使用算法实现的缓存
+// 每个访问频次对应一条双向链表 淘汰时从最低频次链表的链尾移除 同频次下按LRU淘汰
+type LFU struct {
+	capacity   int64 // Cache 最大容量(Byte)
+	length     int64 // Cache 当前容量(Byte)
+	hashmap    map[string]*list.Element
+	freqLists  map[int]*list.List // 访问频次 -> 链表 链头表示最近使用
+	minFreq    int                // 当前最低访问频次
+	defaultTTL time.Duration
+	sizer      Sizer
+
+	callback OnEliminated
+}
+
+// NewLFU 创建指定最大容量的LFU缓存。
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func NewLFU(maxBytes int64, callback OnEliminated) *LFU {
+	return &LFU{
+		capacity:  maxBytes,
+		hashmap:   make(map[string]*list.Element),
+		freqLists: make(map[int]*list.List),
+		sizer:     defaultSizer,
+		callback:  callback,
+	}
+}
+
+// SetDefaultTTL 设置 Add 写入时使用的默认过期时间
+func (c *LFU) SetDefaultTTL(ttl time.Duration) {
+	c.defaultTTL = ttl
+}
+
+// SetOnEliminated 设置淘汰回调
+func (c *LFU) SetOnEliminated(callback OnEliminated) {
+	c.callback = callback
+}
+
+// SetSizer 设置计算记录占用字节数的方法 应在写入数据前设置
+func (c *LFU) SetSizer(sizer Sizer) {
+	if sizer == nil {
+		sizer = defaultSizer
+	}
+	c.sizer = sizer
+}
+
+// Len 返回缓存记录个数
+func (c *LFU) Len() int {
+	return len(c.hashmap)
+}
+
+// Bytes 返回缓存当前占用的字节数
+func (c *LFU) Bytes() int64 {
+	return c.length
+}
+
+// Get 从缓存获取对应key的value 并累加其访问频次
+func (c *LFU) Get(key string) (value Lengthable, ok bool) {
+	if elem, ok := c.hashmap[key]; ok {
+		entry := elem.Value.(*Value)
+		if entry.expired(time.Now()) {
+			c.removeElement(elem, ReasonExpired)
+			return nil, false
+		}
+		c.touch(elem)
+		return entry.value, true
+	}
+	return
+}
+
+// Add 使用默认过期时间写入缓存
+func (c *LFU) Add(key string, value Lengthable) {
+	c.AddWithTTL(key, value, c.defaultTTL)
+}
+
+// AddWithTTL 写入缓存 并在ttl后过期 更新已存在的key同样计为一次访问
+func (c *LFU) AddWithTTL(key string, value Lengthable, ttl time.Duration) {
+	kvSize := c.sizer(key, value)
+	if elem, ok := c.hashmap[key]; ok {
+		entry := elem.Value.(*Value)
+		c.length += kvSize - entry.size
+		entry.size = kvSize
+		oldValue := entry.value
+		entry.value = value
+		entry.expire = expireAt(ttl)
+		c.touch(elem)
+		if c.callback != nil {
+			c.callback(key, oldValue, ReasonReplaced)
+		}
+		for c.capacity != 0 && c.length > c.capacity && len(c.hashmap) > 1 {
+			c.Remove()
+		}
+		return
+	}
+
+	// cache 容量检查
+	for c.capacity != 0 && c.length+kvSize > c.capacity && len(c.hashmap) > 0 {
+		c.Remove()
+	}
+	entry := &Value{key: key, value: value, expire: expireAt(ttl), visits: 1, size: kvSize}
+	c.hashmap[key] = c.freqList(1).PushFront(entry)
+	c.minFreq = 1
+	c.length += kvSize
+}
+
+// Remove 淘汰一枚访问频次最低的缓存
+func (c *LFU) Remove() {
+	if len(c.hashmap) == 0 {
+		return
+	}
+	l, ok := c.freqLists[c.minFreq]
+	if !ok {
+		// 记录被删除后minFreq可能失效 重新计算
+		c.minFreq = 0
+		for freq := range c.freqLists {
+			if c.minFreq == 0 || freq < c.minFreq {
+				c.minFreq = freq
+			}
+		}
+		l = c.freqLists[c.minFreq]
+	}
+	c.removeElement(l.Back(), ReasonCapacity)
+}
+
+// Delete 删除key对应的缓存 返回key是否存在
+func (c *LFU) Delete(key string) bool {
+	if elem, ok := c.hashmap[key]; ok {
+		c.removeElement(elem, ReasonDeleted)
+		return true
+	}
+	return false
+}
+
+// Keys 返回所有缓存的key
+func (c *LFU) Keys() []string {
+	keys := make([]string, 0, len(c.hashmap))
+	for key := range c.hashmap {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// RemoveExpired 清除所有已过期的缓存 返回清除的个数
+func (c *LFU) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for _, elem := range c.hashmap {
+		if elem.Value.(*Value).expired(now) {
+			c.removeElement(elem, ReasonExpired)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (c *LFU) freqList(freq int) *list.List {
+	l, ok := c.freqLists[freq]
+	if !ok {
+		l = list.New()
+		c.freqLists[freq] = l
+	}
+	return l
+}
+
+// touch 将记录移动至高一级访问频次的链表
+func (c *LFU) touch(elem *list.Element) {
+	entry := elem.Value.(*Value)
+	c.unlink(elem)
+	if entry.visits == c.minFreq {
+		if _, ok := c.freqLists[entry.visits]; !ok {
+			c.minFreq++
+		}
+	}
+	entry.visits++
+	c.hashmap[entry.key] = c.freqList(entry.visits).PushFront(entry)
+}
+
+// unlink 将记录从其所在的频次链表中摘除 链表为空时一并删除
+func (c *LFU) unlink(elem *list.Element) {
+	freq := elem.Value.(*Value).visits
+	l := c.freqLists[freq]
+	l.Remove(elem)
+	if l.Len() == 0 {
+		delete(c.freqLists, freq)
+	}
+}
+
+func (c *LFU) removeElement(elem *list.Element, reason Reason) {
+	entry := elem.Value.(*Value)
+	c.unlink(elem)
+	delete(c.hashmap, entry.key)
+	c.length -= entry.size
+	if c.callback != nil {
+		c.callback(entry.key, entry.value, reason)
+	}
+}
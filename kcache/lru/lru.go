@@ -1,13 +1,13 @@
 package lru
 
-// lru 包实现了使用最近最久未使用使用算法的缓存功能
+// lru 包实现了多种缓存淘汰算法(LRU/LRU-K/LFU/ARC/2Q/W-TinyLFU/CLOCK)
 // 用于cache内存不足情况下 移除相应缓存记录
 // Warning: lru包不提供并发一致机制
-// TODO: 实现lru-k算法
 
 import (
 	"container/list"
 	"time"
+	"unsafe"
 )
 
 // Lengthable 接口指明对象可以获取自身占有内存空间大小 以字节为单位
@@ -17,13 +17,60 @@ type Lengthable interface {
 
 // Value 定义双向链表节点所存储的对象
 type Value struct {
-	key   string
-	value Lengthable
+	key    string
+	value  Lengthable
 	expire time.Time
+	visits int   // 访问次数 供LRU-K/LFU使用
+	size   int64 // 写入时由Sizer计算的占用字节数
 }
 
-// OnEliminated 当key-value被淘汰时 执行的处理函数
-type OnEliminated func(key string, value Lengthable)
+// Sizer 计算一条缓存记录占用的字节数 用于容量统计
+type Sizer func(key string, value Lengthable) int64
+
+// EntryOverhead 是每条记录除key与value数据外的估算开销(Byte)
+// 包括链表节点、Value与哈希表槽位(约32) 不包括装箱后的value本身 其大小取决于value的类型
+// 堆分配会按大小级别向上取整 实际开销略大
+const EntryOverhead = int64(unsafe.Sizeof(list.Element{})+unsafe.Sizeof(Value{})) + 32
+
+// defaultSizer 只统计key与value数据本身的字节数
+func defaultSizer(key string, value Lengthable) int64 {
+	return int64(len(key)) + int64(value.Len())
+}
+
+// OverheadSizer 在key与value数据之外 为每条记录额外计入overhead字节的开销
+// 使缓存的容量统计更接近实际占用的内存
+func OverheadSizer(overhead int64) Sizer {
+	return func(key string, value Lengthable) int64 {
+		return int64(len(key)) + int64(value.Len()) + overhead
+	}
+}
+
+// Reason 描述一条缓存记录被移除的原因
+type Reason int
+
+const (
+	ReasonCapacity Reason = iota // 容量不足被淘汰
+	ReasonExpired                // 已过期
+	ReasonDeleted                // 被显式删除
+	ReasonReplaced               // 被新值覆盖
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonCapacity:
+		return "capacity"
+	case ReasonExpired:
+		return "expired"
+	case ReasonDeleted:
+		return "deleted"
+	case ReasonReplaced:
+		return "replaced"
+	}
+	return "unknown"
+}
+
+// OnEliminated 当key-value被淘汰、删除或被新值覆盖时 执行的处理函数
+type OnEliminated func(key string, value Lengthable, reason Reason)
 
 // Cache 是LRU算法实现的缓存
 // 参考Leetcode使用哈希表+双向链表实现LRU
@@ -31,7 +78,9 @@ type Cache struct {
 	capacity         int64 // Cache 最大容量(Byte)
 	length           int64 // Cache 当前容量(Byte)
 	hashmap          map[string]*list.Element
-	doublyLinkedList *list.List // 链头表示最近使用
+	doublyLinkedList *list.List    // 链头表示最近使用
+	defaultTTL       time.Duration // Add 写入时使用的默认过期时间 0代表永不过期
+	sizer            Sizer         // 计算记录占用的字节数
 
 	callback OnEliminated
 }
@@ -43,54 +92,190 @@ func New(maxBytes int64, callback OnEliminated) *Cache {
 		capacity:         maxBytes,
 		hashmap:          make(map[string]*list.Element),
 		doublyLinkedList: list.New(),
+		sizer:            defaultSizer,
 		callback:         callback,
 	}
 }
 
+// SetDefaultTTL 设置 Add 写入时使用的默认过期时间
+// ttl <= 0 代表永不过期
+func (c *Cache) SetDefaultTTL(ttl time.Duration) {
+	c.defaultTTL = ttl
+}
+
+// SetOnEliminated 设置淘汰回调
+func (c *Cache) SetOnEliminated(callback OnEliminated) {
+	c.callback = callback
+}
+
+// SetSizer 设置计算记录占用字节数的方法 为nil时只统计key与value数据本身
+// 应在写入数据前设置
+func (c *Cache) SetSizer(sizer Sizer) {
+	if sizer == nil {
+		sizer = defaultSizer
+	}
+	c.sizer = sizer
+}
+
+// Len 返回缓存记录个数
+func (c *Cache) Len() int {
+	return c.doublyLinkedList.Len()
+}
+
+// Bytes 返回缓存当前占用的字节数
+func (c *Cache) Bytes() int64 {
+	return c.length
+}
+
+// expireAt 计算ttl后的过期时刻 ttl <= 0 时返回零值 代表永不过期
+func expireAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// expired 判断缓存记录在now时刻是否已过期
+func (v *Value) expired(now time.Time) bool {
+	return !v.expire.IsZero() && now.After(v.expire)
+}
+
 // Get 从缓存获取对应key的value。
 // ok 指明查询结果 false代表查无此key
+// 已过期的key会在此时被惰性删除
 func (c *Cache) Get(key string) (value Lengthable, ok bool) {
 	if elem, ok := c.hashmap[key]; ok {
-		c.doublyLinkedList.MoveToFront(elem)
 		entry := elem.Value.(*Value)
+		if entry.expired(time.Now()) {
+			c.removeElement(elem, ReasonExpired)
+			return nil, false
+		}
+		c.doublyLinkedList.MoveToFront(elem)
 		return entry.value, true
 	}
 	return
 }
 
-func (c *Cache) Add(key string, value Lengthable) {
-	kvSize := int64(len(key)) + int64(value.Len())
-	// cache 容量检查
-	for c.capacity != 0 && c.length+kvSize > c.capacity {
-		c.Remove()
+// peek 查询key对应的记录 但不调整其在链表中的位置
+// 已过期的key会在此时被惰性删除
+func (c *Cache) peek(key string) (*Value, bool) {
+	if elem, ok := c.hashmap[key]; ok {
+		entry := elem.Value.(*Value)
+		if entry.expired(time.Now()) {
+			c.removeElement(elem, ReasonExpired)
+			return nil, false
+		}
+		return entry, true
 	}
+	return nil, false
+}
+
+// Add 使用默认过期时间写入缓存
+func (c *Cache) Add(key string, value Lengthable) {
+	c.AddWithTTL(key, value, c.defaultTTL)
+}
+
+// AddWithTTL 写入缓存 并在ttl后过期
+// ttl <= 0 代表永不过期
+func (c *Cache) AddWithTTL(key string, value Lengthable, ttl time.Duration) {
+	c.add(key, value, expireAt(ttl))
+}
+
+// add 写入缓存 并指定其过期时刻 零值代表永不过期
+func (c *Cache) add(key string, value Lengthable, expire time.Time) *Value {
+	kvSize := c.sizer(key, value)
 	if elem, ok := c.hashmap[key]; ok {
 		// 更新缓存key值
 		c.doublyLinkedList.MoveToFront(elem)
 		oldEntry := elem.Value.(*Value)
 		// 先更新写入字节 再更新
-		c.length += int64(value.Len()) - int64(oldEntry.value.Len())
+		c.length += kvSize - oldEntry.size
+		oldEntry.size = kvSize
+		oldValue := oldEntry.value
 		oldEntry.value = value
-	} else {
-		// 新增缓存key
-		elem := c.doublyLinkedList.PushFront(&Value{key: key, value: value})
-		c.hashmap[key] = elem
-		c.length += kvSize
+		oldEntry.expire = expire
+		if c.callback != nil {
+			c.callback(key, oldValue, ReasonReplaced)
+		}
+		// 只为增加的字节淘汰其他记录 被更新的key位于链头 不会被淘汰
+		for c.capacity != 0 && c.length > c.capacity && c.doublyLinkedList.Len() > 1 {
+			c.Remove()
+		}
+		return oldEntry
 	}
+	// cache 容量检查
+	for c.capacity != 0 && c.length+kvSize > c.capacity && c.doublyLinkedList.Len() > 0 {
+		c.Remove()
+	}
+	// 新增缓存key
+	entry := &Value{key: key, value: value, expire: expire, size: kvSize}
+	c.hashmap[key] = c.doublyLinkedList.PushFront(entry)
+	c.length += kvSize
+	return entry
 }
 
 // Remove 淘汰一枚最近最不常用缓存
 func (c *Cache) Remove() {
 	tailElem := c.doublyLinkedList.Back()
 	if tailElem != nil {
-		entry := tailElem.Value.(*Value)
-		k, v := entry.key, entry.value
-		delete(c.hashmap, k)                       // 移除映射
-		c.doublyLinkedList.Remove(tailElem)        // 移除缓存
-		c.length -= int64(len(k)) + int64(v.Len()) // 更新占用内存情况
-		// 移除后的善后处理
-		if c.callback != nil {
-			c.callback(k, v)
+		c.removeElement(tailElem, ReasonCapacity)
+	}
+}
+
+// Delete 删除key对应的缓存 返回key是否存在
+func (c *Cache) Delete(key string) bool {
+	if elem, ok := c.hashmap[key]; ok {
+		c.removeElement(elem, ReasonDeleted)
+		return true
+	}
+	return false
+}
+
+// Keys 返回所有缓存的key 按最近使用到最久未使用排列
+func (c *Cache) Keys() []string {
+	keys := make([]string, 0, c.doublyLinkedList.Len())
+	for elem := c.doublyLinkedList.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*Value).key)
+	}
+	return keys
+}
+
+// RemoveExpired 清除所有已过期的缓存 返回清除的个数
+// 供后台清理协程定期调用 回收过期记录占用的内存
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for elem := c.doublyLinkedList.Back(); elem != nil; {
+		prev := elem.Prev()
+		if elem.Value.(*Value).expired(now) {
+			c.removeElement(elem, ReasonExpired)
+			removed++
 		}
+		elem = prev
 	}
+	return removed
+}
+
+func (c *Cache) removeElement(elem *list.Element, reason Reason) {
+	entry := c.detachElement(elem)
+	// 移除后的善后处理
+	if c.callback != nil {
+		c.callback(entry.key, entry.value, reason)
+	}
+}
+
+// detach 将key从缓存中摘除但不触发淘汰回调 用于记录在不同队列间迁移
+func (c *Cache) detach(key string) (*Value, bool) {
+	if elem, ok := c.hashmap[key]; ok {
+		return c.detachElement(elem), true
+	}
+	return nil, false
+}
+
+func (c *Cache) detachElement(elem *list.Element) *Value {
+	entry := elem.Value.(*Value)
+	delete(c.hashmap, entry.key)    // 移除映射
+	c.doublyLinkedList.Remove(elem) // 移除缓存
+	c.length -= entry.size          // 更新占用内存情况
+	return entry
 }
@@ -0,0 +1,117 @@
+package lru
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+// eliminated 记录淘汰回调收到的key与原因
+type eliminated struct {
+	keys    []string
+	reasons []Reason
+}
+
+func (e *eliminated) callback(key string, value Lengthable, reason Reason) {
+	e.keys = append(e.keys, key)
+	e.reasons = append(e.reasons, reason)
+}
+
+func TestGet(t *testing.T) {
+	lru := New(0, nil)
+	lru.Add("key1", String("1234"))
+	if v, ok := lru.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := lru.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestRemoveOldest(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	capacity := int64(len(k1 + k2 + v1 + v2))
+	var e eliminated
+	lru := New(capacity, e.callback)
+	lru.Add(k1, String(v1))
+	lru.Add(k2, String(v2))
+	lru.Get(k1)
+	lru.Add(k3, String(v3))
+
+	if _, ok := lru.Get(k2); ok || lru.Len() != 2 {
+		t.Fatalf("RemoveOldest key2 failed")
+	}
+	if !reflect.DeepEqual(e.keys, []string{k2}) || e.reasons[0] != ReasonCapacity {
+		t.Fatalf("unexpected eliminations %v %v", e.keys, e.reasons)
+	}
+}
+
+func TestUpdateDoesNotEvict(t *testing.T) {
+	var e eliminated
+	lru := New(27, e.callback)
+	for i := 0; i < 3; i++ {
+		lru.Add(fmt.Sprintf("key%d", i), String("value"))
+	}
+	// 缓存已满 更新同样大小的值不应淘汰任何记录
+	lru.Add("key0", String("VALUE"))
+	if lru.Len() != 3 || lru.Bytes() != 27 {
+		t.Fatalf("len=%d bytes=%d, want 3 and 27", lru.Len(), lru.Bytes())
+	}
+	if !reflect.DeepEqual(e.reasons, []Reason{ReasonReplaced}) {
+		t.Fatalf("unexpected eliminations %v %v", e.keys, e.reasons)
+	}
+
+	// 值变大时只为增加的字节淘汰最久未使用的记录
+	lru.Add("key0", String("value+"))
+	if _, ok := lru.Get("key0"); !ok {
+		t.Fatalf("updated key was evicted")
+	}
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 2 {
+		t.Fatalf("key1 should be evicted")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	var e eliminated
+	lru := New(0, e.callback)
+	lru.AddWithTTL("key1", String("1"), 10*time.Millisecond)
+	lru.AddWithTTL("key2", String("2"), 10*time.Millisecond)
+	lru.Add("key3", String("3"))
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("key1 should be expired")
+	}
+	if n := lru.RemoveExpired(); n != 1 {
+		t.Fatalf("RemoveExpired removed %d, want 1", n)
+	}
+	if lru.Len() != 1 || lru.Bytes() != int64(len("key3")+1) {
+		t.Fatalf("len=%d bytes=%d after expiration", lru.Len(), lru.Bytes())
+	}
+	for _, r := range e.reasons {
+		if r != ReasonExpired {
+			t.Fatalf("unexpected reason %s", r)
+		}
+	}
+}
+
+func TestOverheadSizer(t *testing.T) {
+	lru := New(0, nil)
+	lru.SetSizer(OverheadSizer(100))
+	lru.Add("key", String("value"))
+	if lru.Bytes() != 108 {
+		t.Fatalf("bytes=%d, want 108", lru.Bytes())
+	}
+	lru.Delete("key")
+	if lru.Bytes() != 0 {
+		t.Fatalf("bytes=%d after delete, want 0", lru.Bytes())
+	}
+}
@@ -0,0 +1,130 @@
+package lru
+
+import "time"
+
+// historyRatio 历史队列占总容量的比例的倒数 即历史队列占用1/4的容量
+const historyRatio = 4
+
+// LRUK 是LRU-K算法实现的缓存
+// 访问次数不足K次的key存放在历史队列中 达到K次后才晋升至缓存队列
+// 这样一次性的批量扫描只会淘汰历史队列中的记录 不会冲刷掉缓存队列中的热点数据
+type LRUK struct {
+	k       int
+	history *Cache // 访问次数不足K次的记录 同样按LRU淘汰
+	cache   *Cache // 访问次数达到K次的记录
+}
+
+// NewLRUK 创建指定最大容量的LRU-K缓存 k < 1时按1处理 此时退化为LRU
+// 历史队列占用maxBytes的1/4 其余为缓存队列。
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func NewLRUK(k int, maxBytes int64, callback OnEliminated) *LRUK {
+	if k < 1 {
+		k = 1
+	}
+	historyBytes := maxBytes / historyRatio
+	return &LRUK{
+		k:       k,
+		history: New(historyBytes, callback),
+		cache:   New(maxBytes-historyBytes, callback),
+	}
+}
+
+// SetDefaultTTL 设置 Add 写入时使用的默认过期时间
+// ttl <= 0 代表永不过期
+func (c *LRUK) SetDefaultTTL(ttl time.Duration) {
+	c.history.SetDefaultTTL(ttl)
+	c.cache.SetDefaultTTL(ttl)
+}
+
+// Get 从缓存获取对应key的value。
+// 命中历史队列会累加访问次数 达到K次时晋升至缓存队列
+func (c *LRUK) Get(key string) (value Lengthable, ok bool) {
+	if value, ok = c.cache.Get(key); ok {
+		return value, true
+	}
+	if value, ok = c.history.Get(key); ok {
+		entry := c.history.hashmap[key].Value.(*Value)
+		entry.visits++
+		c.promote(entry)
+		return value, true
+	}
+	return nil, false
+}
+
+// Add 使用默认过期时间写入缓存
+func (c *LRUK) Add(key string, value Lengthable) {
+	c.AddWithTTL(key, value, c.cache.defaultTTL)
+}
+
+// AddWithTTL 写入缓存 并在ttl后过期
+// 新key首先进入历史队列 写入同样计为一次访问
+func (c *LRUK) AddWithTTL(key string, value Lengthable, ttl time.Duration) {
+	if _, ok := c.cache.hashmap[key]; ok {
+		c.cache.AddWithTTL(key, value, ttl)
+		return
+	}
+
+	visits := 1
+	if elem, ok := c.history.hashmap[key]; ok {
+		visits += elem.Value.(*Value).visits
+	}
+	entry := c.history.add(key, value, expireAt(ttl))
+	entry.visits = visits
+	c.promote(entry)
+}
+
+// promote 访问次数达到K次的记录从历史队列晋升至缓存队列
+func (c *LRUK) promote(entry *Value) {
+	if entry.visits < c.k {
+		return
+	}
+	c.history.detach(entry.key)
+	entry = c.cache.add(entry.key, entry.value, entry.expire)
+	entry.visits = 0
+}
+
+// Remove 淘汰一枚缓存 优先淘汰历史队列中的记录
+func (c *LRUK) Remove() {
+	if c.history.doublyLinkedList.Len() > 0 {
+		c.history.Remove()
+		return
+	}
+	c.cache.Remove()
+}
+
+// Delete 删除key对应的缓存 返回key是否存在
+func (c *LRUK) Delete(key string) bool {
+	return c.cache.Delete(key) || c.history.Delete(key)
+}
+
+// Keys 返回所有缓存的key 包括历史队列中的记录
+func (c *LRUK) Keys() []string {
+	return append(c.cache.Keys(), c.history.Keys()...)
+}
+
+// RemoveExpired 清除所有已过期的缓存 返回清除的个数
+func (c *LRUK) RemoveExpired() int {
+	return c.history.RemoveExpired() + c.cache.RemoveExpired()
+}
+
+// SetOnEliminated 设置淘汰回调
+func (c *LRUK) SetOnEliminated(callback OnEliminated) {
+	c.history.SetOnEliminated(callback)
+	c.cache.SetOnEliminated(callback)
+}
+
+// SetSizer 设置计算记录占用字节数的方法 应在写入数据前设置
+func (c *LRUK) SetSizer(sizer Sizer) {
+	c.history.SetSizer(sizer)
+	c.cache.SetSizer(sizer)
+}
+
+// Len 返回缓存记录个数 包括历史队列中的记录
+func (c *LRUK) Len() int {
+	return c.history.Len() + c.cache.Len()
+}
+
+// Bytes 返回缓存当前占用的字节数 包括历史队列中的记录
+func (c *LRUK) Bytes() int64 {
+	return c.history.Bytes() + c.cache.Bytes()
+}
@@ -0,0 +1,58 @@
+package lru
+
+import "time"
+
+// Policy 定义了缓存淘汰策略需具备的能力
+// Cache(LRU)、LRUK、LFU、ARC、TwoQ、WTinyLFU、Clock 均实现了此接口 可相互替换
+type Policy interface {
+	// Add 使用默认过期时间写入缓存
+	Add(key string, value Lengthable)
+	// AddWithTTL 写入缓存 并在ttl后过期 ttl <= 0 代表永不过期
+	AddWithTTL(key string, value Lengthable, ttl time.Duration)
+	// Get 从缓存获取对应key的value 已过期的key视为不存在
+	Get(key string) (Lengthable, bool)
+	// Remove 按照策略淘汰一枚缓存
+	Remove()
+	// Delete 删除key对应的缓存 返回key是否存在
+	Delete(key string) bool
+	// Keys 返回所有缓存的key
+	Keys() []string
+	// RemoveExpired 清除所有已过期的缓存 返回清除的个数
+	RemoveExpired() int
+	// Len 返回缓存记录个数
+	Len() int
+	// Bytes 返回缓存当前占用的字节数
+	Bytes() int64
+	// SetDefaultTTL 设置 Add 写入时使用的默认过期时间
+	SetDefaultTTL(ttl time.Duration)
+	// SetOnEliminated 设置淘汰回调
+	SetOnEliminated(callback OnEliminated)
+	// SetSizer 设置计算记录占用字节数的方法 应在写入数据前设置
+	SetSizer(sizer Sizer)
+}
+
+// SharedGetter 由命中时不修改内部结构的淘汰策略实现
+// GetShared 可以被多个goroutine在读锁下并发调用 但不能与写操作并发
+type SharedGetter interface {
+	GetShared(key string) (Lengthable, bool)
+}
+
+// ghost 是ARC/2Q幽灵队列中的占位值 只记录被淘汰记录的大小 不持有数据
+type ghost int
+
+func (g ghost) Len() int {
+	return int(g)
+}
+
+// 测试各淘汰算法是否实现了Policy接口
+var (
+	_ Policy = (*Cache)(nil)
+	_ Policy = (*LRUK)(nil)
+	_ Policy = (*LFU)(nil)
+	_ Policy = (*ARC)(nil)
+	_ Policy = (*TwoQ)(nil)
+	_ Policy = (*WTinyLFU)(nil)
+	_ Policy = (*Clock)(nil)
+
+	_ SharedGetter = (*Clock)(nil)
+)
@@ -0,0 +1,171 @@
+package lru
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+var policies = map[string]func(maxBytes int64, callback OnEliminated) Policy{
+	"LRU":      func(n int64, cb OnEliminated) Policy { return New(n, cb) },
+	"LRU-2":    func(n int64, cb OnEliminated) Policy { return NewLRUK(2, n, cb) },
+	"LFU":      func(n int64, cb OnEliminated) Policy { return NewLFU(n, cb) },
+	"ARC":      func(n int64, cb OnEliminated) Policy { return NewARC(n, cb) },
+	"2Q":       func(n int64, cb OnEliminated) Policy { return NewTwoQ(n, cb) },
+	"WTinyLFU": func(n int64, cb OnEliminated) Policy { return NewWTinyLFU(n, cb) },
+	"Clock":    func(n int64, cb OnEliminated) Policy { return NewClock(n, cb) },
+}
+
+// TestPolicyAccounting 随机读写后 各策略占用的字节数不超过容量
+// 且与写入的记录减去回调中被移除的记录一致
+func TestPolicyAccounting(t *testing.T) {
+	for name, newPolicy := range policies {
+		t.Run(name, func(t *testing.T) {
+			const capacity = 1000
+			live := make(map[string]int64)
+			p := newPolicy(capacity, func(key string, value Lengthable, reason Reason) {
+				if reason != ReasonReplaced {
+					delete(live, key)
+				}
+			})
+
+			rnd := rand.New(rand.NewSource(1))
+			for i := 0; i < 10000; i++ {
+				key := fmt.Sprintf("key%d", rnd.Intn(200))
+				switch rnd.Intn(10) {
+				case 0:
+					existed := live[key] != 0
+					if p.Delete(key) != existed {
+						t.Fatalf("Delete(%s) disagrees with callbacks", key)
+					}
+				case 1, 2, 3:
+					value := String(make([]byte, 1+rnd.Intn(20)))
+					// 新记录可能在写入时就被淘汰(如W-TinyLFU的准入过滤) 先记录再写入
+					live[key] = int64(len(key) + len(value))
+					p.Add(key, value)
+				default:
+					if _, ok := p.Get(key); ok && live[key] == 0 {
+						t.Fatalf("Get(%s) hit an eliminated key", key)
+					}
+				}
+
+				var bytes int64
+				for _, n := range live {
+					bytes += n
+				}
+				if p.Bytes() != bytes || p.Len() != len(live) {
+					t.Fatalf("bytes=%d len=%d, callbacks say %d and %d", p.Bytes(), p.Len(), bytes, len(live))
+				}
+				if p.Bytes() > capacity {
+					t.Fatalf("bytes=%d exceeds capacity", p.Bytes())
+				}
+			}
+			if len(p.Keys()) != p.Len() {
+				t.Fatalf("Keys returned %d keys, Len is %d", len(p.Keys()), p.Len())
+			}
+		})
+	}
+}
+
+// TestPolicyUnlimited 容量为0时不淘汰任何记录
+func TestPolicyUnlimited(t *testing.T) {
+	for name, newPolicy := range policies {
+		t.Run(name, func(t *testing.T) {
+			evictions := 0
+			p := newPolicy(0, func(string, Lengthable, Reason) { evictions++ })
+			for i := 0; i < 1000; i++ {
+				p.Add(fmt.Sprintf("key%d", i), String("value"))
+			}
+			if p.Len() != 1000 || evictions != 0 {
+				t.Fatalf("len=%d evictions=%d, want 1000 and 0", p.Len(), evictions)
+			}
+		})
+	}
+}
+
+// scan 写入n个只访问一次的key 每次调用使用不同的key
+func scan(p Policy, n int) {
+	for i := 0; i < n; i++ {
+		scans++
+		p.Add(fmt.Sprintf("scan%06d", scans), String("value"))
+	}
+}
+
+var scans int
+
+// TestScanResistance 一次性的批量扫描不应冲刷掉多次访问过的热点数据
+func TestScanResistance(t *testing.T) {
+	for _, name := range []string{"LRU-2", "ARC", "WTinyLFU"} {
+		t.Run(name, func(t *testing.T) {
+			p := policies[name](1000, nil)
+			p.Add("hot", String("value"))
+			for i := 0; i < 10; i++ {
+				p.Get("hot")
+				// W-TinyLFU的新记录需要被挤出窗口后才能进入主缓存
+				p.Add(fmt.Sprintf("warm%d", i), String("value"))
+			}
+			p.Get("hot")
+			scan(p, 1000)
+			if _, ok := p.Get("hot"); !ok {
+				t.Fatalf("hot key was flushed by a scan")
+			}
+		})
+	}
+}
+
+func TestLFUEvictsLeastFrequent(t *testing.T) {
+	var e eliminated
+	lfu := NewLFU(30, e.callback)
+	lfu.Add("key1", String("value"))
+	lfu.Add("key2", String("value"))
+	lfu.Add("key3", String("value"))
+	lfu.Get("key1")
+	lfu.Get("key1")
+	lfu.Get("key3")
+	lfu.Add("key4", String("value"))
+
+	if len(e.keys) != 1 || e.keys[0] != "key2" || e.reasons[0] != ReasonCapacity {
+		t.Fatalf("unexpected eliminations %v %v", e.keys, e.reasons)
+	}
+	// 同频次下按LRU淘汰
+	lfu.Add("key5", String("value"))
+	if e.keys[1] != "key4" {
+		t.Fatalf("evicted %s, want key4", e.keys[1])
+	}
+}
+
+func TestLRUKPromotion(t *testing.T) {
+	lruk := NewLRUK(2, 400, nil)
+	lruk.Add("key", String("value"))
+	if _, ok := lruk.history.hashmap["key"]; !ok {
+		t.Fatalf("new key should enter the history queue")
+	}
+	lruk.Get("key")
+	if _, ok := lruk.cache.hashmap["key"]; !ok {
+		t.Fatalf("key should be promoted after 2 visits")
+	}
+}
+
+func TestTwoQReadmission(t *testing.T) {
+	q := NewTwoQ(80, nil)
+	q.Add("key", String("value"))
+	// 挤出a1in 记入a1out
+	for i := 0; q.a1in.hashmap["key"] != nil; i++ {
+		q.Add(fmt.Sprintf("fill%d", i), String("value"))
+	}
+	if _, ok := q.Get("key"); ok {
+		t.Fatalf("key should be evicted from a1in")
+	}
+	if _, ok := q.a1out.hashmap["key"]; !ok {
+		t.Fatalf("evicted key should be remembered in a1out")
+	}
+	// 在a1out中再次写入 进入am
+	q.Add("key", String("value"))
+	if _, ok := q.am.hashmap["key"]; !ok {
+		t.Fatalf("readmitted key should enter am")
+	}
+	scan(q, 10)
+	if _, ok := q.Get("key"); !ok {
+		t.Fatalf("key in am was flushed by a scan")
+	}
+}
@@ -0,0 +1,138 @@
+package lru
+
+import "time"
+
+const (
+	twoQInRatio  = 4 // a1in 占总容量的1/4
+	twoQOutRatio = 2 // a1out 最多记录总容量1/2的数据
+)
+
+// TwoQ 是2Q算法实现的缓存
+// 新记录首先进入FIFO队列a1in 被淘汰后其key记入幽灵队列a1out
+// 若记录在a1out中时被再次写入 说明它并非一次性访问 此时放入LRU队列am
+// 一次性的批量扫描只会流经a1in 不会挤出am中的热点数据
+type TwoQ struct {
+	capacity int64 // Cache 最大容量(Byte)
+	a1in     *Cache
+	a1out    *Cache
+	am       *Cache
+
+	callback OnEliminated
+}
+
+// NewTwoQ 创建指定最大容量的2Q缓存。
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func NewTwoQ(maxBytes int64, callback OnEliminated) *TwoQ {
+	// a1in/am 的容量由TwoQ统一管理
+	return &TwoQ{
+		capacity: maxBytes,
+		a1in:     New(0, callback),
+		a1out:    New(maxBytes/twoQOutRatio, nil),
+		am:       New(0, callback),
+		callback: callback,
+	}
+}
+
+// SetDefaultTTL 设置 Add 写入时使用的默认过期时间
+func (c *TwoQ) SetDefaultTTL(ttl time.Duration) {
+	c.a1in.SetDefaultTTL(ttl)
+	c.am.SetDefaultTTL(ttl)
+}
+
+// SetOnEliminated 设置淘汰回调
+func (c *TwoQ) SetOnEliminated(callback OnEliminated) {
+	c.callback = callback
+	c.a1in.SetOnEliminated(callback)
+	c.am.SetOnEliminated(callback)
+}
+
+// SetSizer 设置计算记录占用字节数的方法 应在写入数据前设置
+func (c *TwoQ) SetSizer(sizer Sizer) {
+	c.a1in.SetSizer(sizer)
+	c.am.SetSizer(sizer)
+}
+
+// Len 返回缓存记录个数 不包括幽灵队列
+func (c *TwoQ) Len() int {
+	return c.a1in.Len() + c.am.Len()
+}
+
+// Bytes 返回缓存当前占用的字节数 不包括幽灵队列
+func (c *TwoQ) Bytes() int64 {
+	return c.a1in.Bytes() + c.am.Bytes()
+}
+
+// Get 从缓存获取对应key的value
+// 命中a1in时不调整其位置 a1in 保持先进先出
+func (c *TwoQ) Get(key string) (value Lengthable, ok bool) {
+	if value, ok = c.am.Get(key); ok {
+		return value, true
+	}
+	if entry, ok := c.a1in.peek(key); ok {
+		return entry.value, true
+	}
+	return nil, false
+}
+
+// Add 使用默认过期时间写入缓存
+func (c *TwoQ) Add(key string, value Lengthable) {
+	c.AddWithTTL(key, value, c.am.defaultTTL)
+}
+
+// AddWithTTL 写入缓存 并在ttl后过期
+func (c *TwoQ) AddWithTTL(key string, value Lengthable, ttl time.Duration) {
+	kvSize := c.a1in.sizer(key, value)
+	expire := expireAt(ttl)
+
+	switch {
+	case c.am.hashmap[key] != nil:
+		c.am.add(key, value, expire)
+		c.reclaim(0)
+	case c.a1in.hashmap[key] != nil:
+		c.a1in.add(key, value, expire)
+		c.reclaim(0)
+	case c.a1out.hashmap[key] != nil:
+		c.a1out.detach(key)
+		c.reclaim(kvSize)
+		c.am.add(key, value, expire)
+	default:
+		c.reclaim(kvSize)
+		c.a1in.add(key, value, expire)
+	}
+}
+
+// reclaim 淘汰记录 直到可以容纳size字节的新记录
+func (c *TwoQ) reclaim(size int64) {
+	for c.capacity != 0 && c.Bytes()+size > c.capacity && c.Len() > 0 {
+		c.Remove()
+	}
+}
+
+// Remove 淘汰一枚缓存
+// a1in 超出其目标容量时从a1in淘汰 并将key记入a1out 否则从am按LRU淘汰
+func (c *TwoQ) Remove() {
+	if c.a1in.Len() > 0 && (c.a1in.Bytes() > c.capacity/twoQInRatio || c.am.Len() == 0) {
+		entry := c.a1in.detachElement(c.a1in.doublyLinkedList.Back())
+		c.a1out.add(entry.key, ghost(entry.size), time.Time{})
+		if c.callback != nil {
+			c.callback(entry.key, entry.value, ReasonCapacity)
+		}
+		return
+	}
+	c.am.Remove()
+}
+
+// Delete 删除key对应的缓存 返回key是否存在
+func (c *TwoQ) Delete(key string) bool {
+	return c.am.Delete(key) || c.a1in.Delete(key)
+}
+
+// Keys 返回所有缓存的key 不包括幽灵队列
+func (c *TwoQ) Keys() []string {
+	return append(c.am.Keys(), c.a1in.Keys()...)
+}
+
+// RemoveExpired 清除所有已过期的缓存 返回清除的个数
+func (c *TwoQ) RemoveExpired() int {
+	return c.a1in.RemoveExpired() + c.am.RemoveExpired()
+}
@@ -0,0 +1,215 @@
+package lru
+
+import (
+	"time"
+
+	"kcache/kcache/tinylfu"
+)
+
+const (
+	windowRatio    = 100 // 窗口队列占总容量的1/100
+	protectedRatio = 5   // 保护队列占主缓存的4/5 其余为试用队列
+	avgEntryBytes  = 64  // 估算记录个数时假定的平均记录大小
+	minCounters    = 1024
+)
+
+// WTinyLFU 是W-TinyLFU算法实现的缓存
+// 新记录首先进入一个很小的LRU窗口队列 被挤出窗口后作为候选者
+// 与主缓存的淘汰者比较TinyLFU估算的访问频次 频次更高才能进入主缓存 否则直接淘汰
+// 主缓存是分段LRU: 新进入的记录位于试用队列 再次命中后晋升至保护队列
+type WTinyLFU struct {
+	capacity     int64 // Cache 最大容量(Byte)
+	mainCap      int64 // 主缓存容量(Byte)
+	protectedCap int64 // 保护队列容量(Byte)
+	window       *Cache
+	probation    *Cache
+	protected    *Cache
+	filter       *tinylfu.TinyLFU
+
+	callback OnEliminated
+}
+
+// NewWTinyLFU 创建指定最大容量的W-TinyLFU缓存。
+// 当maxBytes为0时，代表cache无内存限制，无限存放。
+func NewWTinyLFU(maxBytes int64, callback OnEliminated) *WTinyLFU {
+	windowCap := maxBytes / windowRatio
+	if maxBytes != 0 && windowCap == 0 {
+		windowCap = 1
+	}
+	mainCap := maxBytes - windowCap
+	counters := int(maxBytes / avgEntryBytes)
+	if counters < minCounters {
+		counters = minCounters
+	}
+	// 各队列的容量由WTinyLFU统一管理
+	return &WTinyLFU{
+		capacity:     maxBytes,
+		mainCap:      mainCap,
+		protectedCap: mainCap / protectedRatio * (protectedRatio - 1),
+		window:       New(0, callback),
+		probation:    New(0, callback),
+		protected:    New(0, callback),
+		filter:       tinylfu.New(counters),
+		callback:     callback,
+	}
+}
+
+// SetDefaultTTL 设置 Add 写入时使用的默认过期时间
+func (c *WTinyLFU) SetDefaultTTL(ttl time.Duration) {
+	c.window.SetDefaultTTL(ttl)
+}
+
+// SetOnEliminated 设置淘汰回调
+func (c *WTinyLFU) SetOnEliminated(callback OnEliminated) {
+	c.callback = callback
+	c.window.SetOnEliminated(callback)
+	c.probation.SetOnEliminated(callback)
+	c.protected.SetOnEliminated(callback)
+}
+
+// SetSizer 设置计算记录占用字节数的方法 应在写入数据前设置
+func (c *WTinyLFU) SetSizer(sizer Sizer) {
+	c.window.SetSizer(sizer)
+	c.probation.SetSizer(sizer)
+	c.protected.SetSizer(sizer)
+}
+
+// Len 返回缓存记录个数
+func (c *WTinyLFU) Len() int {
+	return c.window.Len() + c.probation.Len() + c.protected.Len()
+}
+
+// Bytes 返回缓存当前占用的字节数
+func (c *WTinyLFU) Bytes() int64 {
+	return c.window.Bytes() + c.probation.Bytes() + c.protected.Bytes()
+}
+
+// Get 从缓存获取对应key的value 无论是否命中都会计入访问频次
+// 命中试用队列的记录晋升至保护队列
+func (c *WTinyLFU) Get(key string) (value Lengthable, ok bool) {
+	c.filter.Increment(key)
+
+	if value, ok = c.window.Get(key); ok {
+		return value, true
+	}
+	if value, ok = c.protected.Get(key); ok {
+		return value, true
+	}
+	if entry, ok := c.probation.peek(key); ok {
+		c.probation.detach(key)
+		c.protected.add(entry.key, entry.value, entry.expire)
+		c.demote()
+		return entry.value, true
+	}
+	return nil, false
+}
+
+// Add 使用默认过期时间写入缓存
+func (c *WTinyLFU) Add(key string, value Lengthable) {
+	c.AddWithTTL(key, value, c.window.defaultTTL)
+}
+
+// AddWithTTL 写入缓存 并在ttl后过期
+// 新记录写入窗口队列 由准入过滤器决定被挤出窗口的记录能否进入主缓存
+func (c *WTinyLFU) AddWithTTL(key string, value Lengthable, ttl time.Duration) {
+	expire := expireAt(ttl)
+	switch {
+	case c.protected.hashmap[key] != nil:
+		c.protected.add(key, value, expire)
+		c.demote()
+		c.evictMain(nil)
+	case c.probation.hashmap[key] != nil:
+		c.probation.add(key, value, expire)
+		c.evictMain(nil)
+	default:
+		c.window.add(key, value, expire)
+	}
+
+	if c.capacity == 0 {
+		return
+	}
+	for c.window.Bytes() > c.capacity-c.mainCap && c.window.Len() > 0 {
+		c.admit(c.window.detachElement(c.window.doublyLinkedList.Back()))
+	}
+}
+
+// admit 将被挤出窗口的候选者与主缓存的淘汰者比较 决定淘汰哪一方
+func (c *WTinyLFU) admit(candidate *Value) {
+	size := candidate.size
+	if c.probation.Bytes()+c.protected.Bytes()+size > c.mainCap {
+		victim := c.victim()
+		if victim == nil || !c.filter.Admit(candidate.key, victim.key) {
+			c.eliminate(candidate)
+			return
+		}
+	}
+	c.probation.add(candidate.key, candidate.value, candidate.expire)
+	c.evictMain(candidate)
+}
+
+// victim 返回主缓存中下一个将被淘汰的记录
+func (c *WTinyLFU) victim() *Value {
+	if elem := c.probation.doublyLinkedList.Back(); elem != nil {
+		return elem.Value.(*Value)
+	}
+	if elem := c.protected.doublyLinkedList.Back(); elem != nil {
+		return elem.Value.(*Value)
+	}
+	return nil
+}
+
+// evictMain 淘汰主缓存中的记录直至不超出容量 但不会淘汰keep
+func (c *WTinyLFU) evictMain(keep *Value) {
+	for c.probation.Bytes()+c.protected.Bytes() > c.mainCap {
+		victim := c.victim()
+		if victim == nil || victim == keep {
+			return
+		}
+		if _, ok := c.probation.detach(victim.key); !ok {
+			c.protected.detach(victim.key)
+		}
+		c.eliminate(victim)
+	}
+}
+
+// demote 保护队列超出容量时 将其链尾记录降级至试用队列
+func (c *WTinyLFU) demote() {
+	for c.capacity != 0 && c.protected.Bytes() > c.protectedCap && c.protected.Len() > 1 {
+		entry := c.protected.detachElement(c.protected.doublyLinkedList.Back())
+		c.probation.add(entry.key, entry.value, entry.expire)
+	}
+}
+
+func (c *WTinyLFU) eliminate(entry *Value) {
+	if c.callback != nil {
+		c.callback(entry.key, entry.value, ReasonCapacity)
+	}
+}
+
+// Remove 淘汰一枚缓存 优先淘汰主缓存中的记录
+func (c *WTinyLFU) Remove() {
+	if victim := c.victim(); victim != nil {
+		if _, ok := c.probation.detach(victim.key); !ok {
+			c.protected.detach(victim.key)
+		}
+		c.eliminate(victim)
+		return
+	}
+	c.window.Remove()
+}
+
+// Delete 删除key对应的缓存 返回key是否存在
+func (c *WTinyLFU) Delete(key string) bool {
+	return c.window.Delete(key) || c.probation.Delete(key) || c.protected.Delete(key)
+}
+
+// Keys 返回所有缓存的key
+func (c *WTinyLFU) Keys() []string {
+	keys := append(c.window.Keys(), c.protected.Keys()...)
+	return append(keys, c.probation.Keys()...)
+}
+
+// RemoveExpired 清除所有已过期的缓存 返回清除的个数
+func (c *WTinyLFU) RemoveExpired() int {
+	return c.window.RemoveExpired() + c.probation.RemoveExpired() + c.protected.RemoveExpired()
+}
@@ -0,0 +1,266 @@
+package kcache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// multi 模块实现批量获取
+// 本地命中的key直接返回 其余key按负责节点分组
+// 每个远端节点只发送一次GetMulti调用 各节点之间并行执行
+// 需要本地加载的key 在Retriever实现了BatchRetriever或BatchResultRetriever时合并为一次批量加载
+
+// multiResult 汇总GetMulti的结果 可被多个goroutine并发写入
+type multiResult struct {
+	mu     sync.Mutex
+	values map[string]ByteView
+	errs   map[string]error
+}
+
+func newMultiResult(n int) *multiResult {
+	return &multiResult{
+		values: make(map[string]ByteView, n),
+		errs:   make(map[string]error),
+	}
+}
+
+func (r *multiResult) set(key string, value ByteView, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.errs[key] = err
+		return
+	}
+	r.values[key] = value
+}
+
+// GetMulti 批量获取多个key的缓存值
+// values 中为获取成功的key errs 中为获取失败的key及其原因
+func (g *Group) GetMulti(keys []string) (values map[string]ByteView, errs map[string]error) {
+	return g.GetMultiContext(context.Background(), keys)
+}
+
+// GetMultiContext 与 GetMulti 相同 ctx的截止时间与取消会传递给远端节点与Retriever
+func (g *Group) GetMultiContext(ctx context.Context, keys []string) (values map[string]ByteView, errs map[string]error) {
+	res := newMultiResult(len(keys))
+
+	var local []string
+	remote := make(map[Fetcher][]string)
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if key == "" {
+			res.set(key, ByteView{}, fmt.Errorf("key required"))
+			continue
+		}
+		g.stats.gets.Add(1)
+		if value, ok := g.cache.get(key); ok {
+			g.stats.cacheHits.Add(1)
+			res.set(key, value, nil)
+			continue
+		}
+		if value, ok := g.hotcache.get(key); ok {
+			g.stats.hotCacheHits.Add(1)
+			res.set(key, value, nil)
+			continue
+		}
+
+		if g.server != nil {
+			if fetcher, ok := g.server.Pick(key); ok {
+				remote[fetcher] = append(remote[fetcher], key)
+				continue
+			}
+		}
+		local = append(local, key)
+	}
+	log.Printf("GetMulti %d keys: %d local, %d peers", len(seen), len(local), len(remote))
+
+	var wg sync.WaitGroup
+	for fetcher, peerKeys := range remote {
+		wg.Add(1)
+		go func(fetcher Fetcher, peerKeys []string) {
+			defer wg.Done()
+			g.fetchMulti(ctx, fetcher, peerKeys, res)
+		}(fetcher, peerKeys)
+	}
+	if len(local) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if g.server != nil {
+				// 本节点负责这些key 与其他节点的加载协调
+				g.fillMulti(ctx, nil, local, res)
+				return
+			}
+			g.loadMulti(ctx, local, res)
+		}()
+	}
+	wg.Wait()
+
+	return res.values, res.errs
+}
+
+// fetchMulti 通过一次调用从远端节点获取keys 取回的值填充至hotcache
+// 远端节点不支持批量获取时逐个key获取 调用失败时与远端节点协调后在本地加载
+func (g *Group) fetchMulti(ctx context.Context, fetcher Fetcher, keys []string, res *multiResult) {
+	mf, ok := fetcher.(MultiFetcher)
+	if !ok {
+		for _, key := range keys {
+			value, err := g.load(ctx, key)
+			res.set(key, value, err)
+		}
+		return
+	}
+
+	values, errs, err := mf.FetchMulti(ctx, g.name, keys)
+	if err != nil {
+		g.stats.peerErrors.Add(1)
+		log.Printf("fail to get %d keys from peer, %s.\n", len(keys), err.Error())
+		if ctx.Err() != nil {
+			for _, key := range keys {
+				res.set(key, ByteView{}, ctx.Err())
+			}
+			return
+		}
+		g.fillMulti(ctx, fetcher, keys, res)
+		return
+	}
+	g.stats.peerLoads.Add(1)
+	for _, key := range keys {
+		if r, ok := values[key]; ok {
+			res.set(key, g.populateHotCache(key, r), nil)
+		} else if e, ok := errs[key]; ok {
+			res.set(key, ByteView{}, e)
+		} else {
+			res.set(key, ByteView{}, fmt.Errorf("peer returned no result for %s", key))
+		}
+	}
+}
+
+// loadMulti 从本地Retriever加载多个key 能批量加载时合并为一次批量加载 否则逐个key并行加载
+func (g *Group) loadMulti(ctx context.Context, keys []string, res *multiResult) {
+	if g.batchable() && len(keys) > 1 {
+		g.loadLocallyBatch(ctx, keys, res)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			value, err := g.loadLocally(ctx, key)
+			res.set(key, value, err)
+		}(key)
+	}
+	wg.Wait()
+}
+
+// loadLocally 经由singleflight从本地Retriever加载key
+func (g *Group) loadLocally(ctx context.Context, key string) (ByteView, error) {
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, err
+	}
+	view, err, shared := g.flight.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return g.getLocally(ctx, key)
+	})
+	if shared {
+		g.stats.flightDedups.Add(1)
+	}
+	if err != nil {
+		return ByteView{}, err
+	}
+	return view.(ByteView), nil
+}
+
+// loadLocallyBatch 通过一次批量加载从本地数据源取回多个key 并按各自的元数据填充cache
+// 批量加载不经过singleflight 与同一key的并发Get可能各自访问一次数据源
+func (g *Group) loadLocallyBatch(ctx context.Context, keys []string, res *multiResult) {
+	if err := ctx.Err(); err != nil {
+		for _, key := range keys {
+			res.set(key, ByteView{}, err)
+		}
+		return
+	}
+	// 计数器从计数器表恢复 不访问Retriever
+	keys = slices.DeleteFunc(slices.Clone(keys), func(key string) bool {
+		view, ok := g.loadCounter(key)
+		if ok {
+			res.set(key, view, nil)
+		}
+		return ok
+	})
+	if len(keys) == 0 {
+		return
+	}
+	// 一次批量加载只占用一次准入
+	done, err := g.admit()
+	if err != nil {
+		for _, key := range keys {
+			view, err := g.reject(key, err)
+			res.set(key, view, err)
+		}
+		return
+	}
+	log.Printf("Get %d keys from batch retriever", len(keys))
+
+	g.stats.localLoads.Add(int64(len(keys)))
+	start := time.Now()
+	values, errs, err := g.retrieveMulti(ctx, keys)
+	done(err, time.Since(start))
+	if err != nil {
+		g.stats.localLoadErrs.Add(int64(len(keys)))
+		for _, key := range keys {
+			res.set(key, ByteView{}, err)
+		}
+		return
+	}
+
+	for _, key := range keys {
+		if r, ok := values[key]; ok {
+			res.set(key, g.fillResult(key, r), nil)
+			continue
+		}
+		g.stats.localLoadErrs.Add(1)
+		if e, ok := errs[key]; ok {
+			res.set(key, ByteView{}, e)
+		} else {
+			res.set(key, ByteView{}, fmt.Errorf("%s not exist", key))
+		}
+	}
+}
+
+// batchable 判断Retriever能否批量加载 且不会因此丢失元数据
+func (g *Group) batchable() bool {
+	if _, ok := g.retriever.(BatchResultRetriever); ok {
+		return true
+	}
+	if _, ok := g.retriever.(ResultRetriever); ok {
+		return false
+	}
+	_, ok := g.retriever.(BatchRetriever)
+	return ok
+}
+
+// retrieveMulti 向Retriever批量取回数据 Retriever实现了BatchResultRetriever时带有元数据
+func (g *Group) retrieveMulti(ctx context.Context, keys []string) (map[string]Result, map[string]error, error) {
+	if br, ok := g.retriever.(BatchResultRetriever); ok {
+		return br.RetrieveMultiResult(ctx, keys)
+	}
+	values, errs, err := g.retriever.(BatchRetriever).RetrieveMulti(ctx, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	results := make(map[string]Result, len(values))
+	for key, value := range values {
+		results[key] = Result{Value: value}
+	}
+	return results, errs, nil
+}
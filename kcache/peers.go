@@ -1,14 +1,73 @@
 package kcache
 
+import (
+	"context"
+	"time"
+)
+
 // peers 模块
 
 // Picker 定义了获取分布式节点的能力
 type Picker interface {
 	Pick(key string) (Fetcher, bool)
+	// Peers 返回除自身外的全部节点 用于广播
+	Peers() []Fetcher
 }
 
 // Fetcher 定义了从远端获取缓存的能力
-// 所以每个Peer应实现这个接口
+// 所以每个Peer应实现这个接口 ctx的截止时间与取消应传递至远端节点
+// 返回的Result带有远端记录剩余的过期时间、版本以及是否可以缓存
 type Fetcher interface {
-	Fetch(group string, key string) ([]byte, error)
+	Fetch(ctx context.Context, group string, key string) (Result, error)
+}
+
+// MultiFetcher 定义了通过一次调用从远端获取多个key的能力
+// errs 记录远端获取失败的key 返回的error代表整个调用失败
+type MultiFetcher interface {
+	FetchMulti(ctx context.Context, group string, keys []string) (values map[string]Result, errs map[string]error, err error)
+}
+
+// Setter 定义了向远端节点写入缓存的能力 是Fetcher对应的写接口
+// ttl 为0时使用远端Group的默认过期时间
+type Setter interface {
+	Set(group string, key string, value []byte, ttl time.Duration, tags ...string) error
+}
+
+// Filler 定义了将本地加载的结果写回负责节点的能力
+// 与Setter不同 写回的值来自数据源 负责节点不会将它交给Writer 也不会覆盖加载期间写入的值
+type Filler interface {
+	Fill(ctx context.Context, group string, key string, value []byte, ttl time.Duration, tags ...string) error
+}
+
+// Deleter 定义了删除远端缓存的能力
+// Picker 返回的Fetcher若实现了Deleter 即可将删除转发至该节点
+type Deleter interface {
+	Delete(group string, key string) error
+}
+
+// Swapper 定义了在远端节点上执行CompareAndSwap的能力
+// 版本不同时返回 ErrVersionMismatch 与当前的版本
+type Swapper interface {
+	CompareAndSwap(group string, key string, expected uint64, value []byte, ttl time.Duration, tags ...string) (uint64, error)
+}
+
+// Incrementer 定义了在远端节点上原子地增减计数器的能力
+// ttl 为key不存在时新记录的过期时间 0代表使用远端Group的默认过期时间
+type Incrementer interface {
+	Incr(group string, key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// Leaser 定义了向负责节点申请加载lease的能力 用于在集群内协调对同一key的加载
+// 取得lease时返回其id 其他节点持有lease时 AcquireLease等待其释放或过期后返回0
+// TryAcquireLeases 通过一次调用为多个key申请lease 不等待其他节点持有的lease 返回取得lease的key及其id
+type Leaser interface {
+	AcquireLease(ctx context.Context, group string, key string, ttl time.Duration) (uint64, error)
+	TryAcquireLeases(ctx context.Context, group string, keys []string, ttl time.Duration) (map[string]uint64, error)
+	ReleaseLease(ctx context.Context, group string, key string, id uint64) error
+}
+
+// Invalidator 定义了按标签或前缀批量删除远端缓存的能力
+// tag与prefix只能设置一个 返回远端节点删除的key的个数
+type Invalidator interface {
+	Invalidate(group string, tag string, prefix string) (int, error)
 }
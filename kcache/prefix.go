@@ -0,0 +1,144 @@
+package kcache
+
+import (
+	"sort"
+	"strings"
+)
+
+// prefix 模块为cache的每个分片维护一棵基数树 记录分片中的全部key
+// 按前缀删除时只需访问匹配的key 无需遍历整个分片
+// 基数树在分片的锁内维护 与淘汰策略中的内容保持一致
+
+// prefixIndex 是key的前缀索引 零值即可使用
+type prefixIndex struct {
+	root radixNode
+}
+
+// radixNode 是基数树的节点 prefix为从父节点到该节点的边
+type radixNode struct {
+	prefix   string
+	leaf     bool         // 是否有key在该节点结束
+	children []*radixNode // 按prefix的首字节排序
+}
+
+// child 返回首字节为b的子节点及其下标 不存在时返回nil与应插入的位置
+func (n *radixNode) child(b byte) (int, *radixNode) {
+	i := sort.Search(len(n.children), func(i int) bool {
+		return n.children[i].prefix[0] >= b
+	})
+	if i < len(n.children) && n.children[i].prefix[0] == b {
+		return i, n.children[i]
+	}
+	return i, nil
+}
+
+// insert 将key加入索引 key已存在时不做任何事
+func (idx *prefixIndex) insert(key string) {
+	n := &idx.root
+	for key != "" {
+		i, c := n.child(key[0])
+		if c == nil {
+			n.children = append(n.children, nil)
+			copy(n.children[i+1:], n.children[i:])
+			n.children[i] = &radixNode{prefix: key, leaf: true}
+			return
+		}
+
+		l := commonPrefix(c.prefix, key)
+		if l < len(c.prefix) {
+			// key与边只有部分相同 在相同的部分拆分出一个中间节点
+			split := &radixNode{prefix: c.prefix[:l], children: []*radixNode{c}}
+			c.prefix = c.prefix[l:]
+			n.children[i] = split
+			c = split
+		}
+		n, key = c, key[l:]
+	}
+	n.leaf = true
+}
+
+// remove 将key移出索引 返回key是否存在
+func (idx *prefixIndex) remove(key string) bool {
+	if key == "" {
+		ok := idx.root.leaf
+		idx.root.leaf = false
+		return ok
+	}
+	return idx.root.remove(key)
+}
+
+// remove 删除n之下的key 并合并不再需要的节点 key不为空
+func (n *radixNode) remove(key string) bool {
+	i, c := n.child(key[0])
+	if c == nil || !strings.HasPrefix(key, c.prefix) {
+		return false
+	}
+	rest := key[len(c.prefix):]
+	if rest == "" {
+		if !c.leaf {
+			return false
+		}
+		c.leaf = false
+	} else if !c.remove(rest) {
+		return false
+	}
+
+	if !c.leaf {
+		switch len(c.children) {
+		case 0:
+			n.children = append(n.children[:i], n.children[i+1:]...)
+		case 1:
+			// 只剩一个子节点 与其合并为一条边
+			gc := c.children[0]
+			gc.prefix = c.prefix + gc.prefix
+			n.children[i] = gc
+		}
+	}
+	return true
+}
+
+// match 返回以prefix开头的全部key
+func (idx *prefixIndex) match(prefix string) []string {
+	n, path := &idx.root, ""
+	for prefix != "" {
+		_, c := n.child(prefix[0])
+		if c == nil {
+			return nil
+		}
+		switch {
+		case strings.HasPrefix(prefix, c.prefix):
+			prefix = prefix[len(c.prefix):]
+		case strings.HasPrefix(c.prefix, prefix):
+			// prefix在这条边的中间结束 c之下的key都以prefix开头
+			prefix = ""
+		default:
+			return nil
+		}
+		n, path = c, path+c.prefix
+	}
+
+	var keys []string
+	n.walk(path, &keys)
+	return keys
+}
+
+// walk 收集n之下的全部key path为从根节点到n的边
+func (n *radixNode) walk(path string, keys *[]string) {
+	if n.leaf {
+		*keys = append(*keys, path)
+	}
+	for _, c := range n.children {
+		c.walk(path+c.prefix, keys)
+	}
+}
+
+// commonPrefix 返回a与b相同前缀的长度
+func commonPrefix(a, b string) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,120 @@
+package kcache
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sorted(keys []string) []string {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPrefixIndex(t *testing.T) {
+	var idx prefixIndex
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "user:1", "user:10", "user:2", "item:1"}
+	for _, key := range keys {
+		idx.insert(key)
+	}
+	idx.insert("abc")
+
+	for _, c := range []struct {
+		prefix string
+		want   []string
+	}{
+		{"", keys},
+		{"a", []string{"a", "ab", "abc", "abd"}},
+		{"ab", []string{"ab", "abc", "abd"}},
+		{"abc", []string{"abc"}},
+		{"abcd", nil},
+		{"user:", []string{"user:1", "user:10", "user:2"}},
+		{"user:1", []string{"user:1", "user:10"}},
+		{"us", []string{"user:1", "user:10", "user:2"}},
+		{"x", nil},
+	} {
+		if got := sorted(idx.match(c.prefix)); !reflect.DeepEqual(got, sorted(c.want)) {
+			t.Errorf("match(%q) = %q, want %q", c.prefix, got, sorted(c.want))
+		}
+	}
+
+	if idx.remove("abe") || idx.remove("us") {
+		t.Fatalf("removed a key that does not exist")
+	}
+	for _, key := range keys {
+		if !idx.remove(key) {
+			t.Fatalf("remove(%q) = false", key)
+		}
+		if idx.remove(key) {
+			t.Fatalf("remove(%q) twice", key)
+		}
+	}
+	if len(idx.root.children) != 0 || idx.root.leaf {
+		t.Fatalf("index not empty after removing every key")
+	}
+}
+
+// TestPrefixIndexConsistent 各淘汰策略淘汰、删除与覆盖记录后 前缀索引与分片中的key保持一致
+func TestPrefixIndexConsistent(t *testing.T) {
+	for _, p := range []struct {
+		name   string
+		policy Policy
+	}{
+		{"LRU", LRUPolicy()},
+		{"LRUK", LRUKPolicy(2)},
+		{"LFU", LFUPolicy()},
+		{"ARC", ARCPolicy()},
+		{"TwoQ", TwoQPolicy()},
+		{"WTinyLFU", WTinyLFUPolicy()},
+		{"Clock", ClockPolicy()},
+	} {
+		t.Run(p.name, func(t *testing.T) {
+			c := newCache(4 << 10)
+			c.policy = p.policy
+			c.shardNum = 4
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 5000; i++ {
+				prefix := "user:"
+				if r.Intn(2) == 0 {
+					prefix = "item:"
+				}
+				key := fmt.Sprintf("%s%d", prefix, r.Intn(300))
+				switch r.Intn(10) {
+				case 0:
+					c.remove(key)
+				default:
+					c.add(key, ByteView{b: make([]byte, r.Intn(32))})
+				}
+				if r.Intn(4) == 0 {
+					c.get(key)
+				}
+			}
+
+			var users int
+			for _, s := range c.shards {
+				if got, want := sorted(s.keys.match("")), sorted(s.lru.Keys()); !reflect.DeepEqual(got, want) {
+					t.Fatalf("index %q, shard %q", got, want)
+				}
+				for _, key := range s.lru.Keys() {
+					if strings.HasPrefix(key, "user:") {
+						users++
+					}
+				}
+			}
+			if n := c.removePrefix("user:"); n != users {
+				t.Fatalf("removePrefix = %d, want %d", n, users)
+			}
+			for _, s := range c.shards {
+				for _, key := range s.lru.Keys() {
+					if strings.HasPrefix(key, "user:") {
+						t.Fatalf("%s not removed", key)
+					}
+				}
+			}
+		})
+	}
+}
@@ -14,8 +14,8 @@ import (
 )
 
 // EtcdDial 向grpc请求一个服务
-// 通过提供一个etcd client和service name即可获得Connection
-func EtcdDial(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
+// 通过提供一个etcd client、服务所在的target和service name即可获得Connection
+func EtcdDial(c *clientv3.Client, target string, service string) (*grpc.ClientConn, error) {
 	/*
 		etcdResolver, err := resolver.NewBuilder(c)
 		if err != nil {
@@ -29,7 +29,7 @@ func EtcdDial(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
 			grpc.WithBlock(),
 		)*/
 
-	em, _ := endpoints.NewManager(c, "kcache")
+	em, _ := endpoints.NewManager(c, target)
 
 	mp, _ := em.List(c.Ctx())
 
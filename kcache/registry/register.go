@@ -6,19 +6,11 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/naming/endpoints"
 )
 
-var (
-	defaultEtcdConfig = clientv3.Config{
-		Endpoints:   []string{"localhost:2379"},
-		DialTimeout: 5 * time.Second,
-	}
-)
-
 // etcdAdd 在租赁模式添加一对kv至etcd
 func etcdAdd(c *clientv3.Client, lid clientv3.LeaseID, service string, addr string) error {
 	em, err := endpoints.NewManager(c, service)
@@ -33,19 +25,19 @@ func etcdAdd(c *clientv3.Client, lid clientv3.LeaseID, service string, addr stri
 	return em.AddEndpoint(c.Ctx(), service+"/"+addr, ep, clientv3.WithLease(lid))
 }
 
-// Register 注册一个服务至etcd
-// 注意 Register将不会return 如果没有error的话
-func Register(service string, addr string, stop chan error) error {
+// Register 通过config指定的etcd注册一个服务 注册完成后返回
+// 之后在后台维持租约心跳 直到stop被关闭或收到信号 此时撤销服务
+func Register(config clientv3.Config, service string, addr string, stop chan error) error {
 	// 创建一个etcd client
-	cli, err := clientv3.New(defaultEtcdConfig)
+	cli, err := clientv3.New(config)
 	if err != nil {
 		return fmt.Errorf("create etcd client failed: %v", err)
 	}
-	defer cli.Close()
 
 	// 创建一个租约 配置5秒过期
 	resp, err := cli.Grant(context.Background(), 5)
 	if err != nil {
+		cli.Close()
 		return fmt.Errorf("create lease failed: %v", err)
 	}
 	leaseId := resp.ID
@@ -53,33 +45,48 @@ func Register(service string, addr string, stop chan error) error {
 	// 注册服务
 	err = etcdAdd(cli, leaseId, service, addr)
 	if err != nil {
+		cli.Close()
 		return fmt.Errorf("add etcd record failed: %v", err)
 	}
 
 	// 设置服务心跳检测
 	ch, err := cli.KeepAlive(context.Background(), leaseId)
 	if err != nil {
+		cli.Close()
 		return fmt.Errorf("set keepalive failed: %v", err)
 	}
 
 	log.Printf("[%s] register service ok\n", addr)
 
+	go keepAlive(cli, leaseId, ch, stop)
+	return nil
+}
+
+// keepAlive 监听租约心跳 stop被关闭或收到信号后撤销租约 使服务立即下线
+func keepAlive(cli *clientv3.Client, leaseId clientv3.LeaseID, ch <-chan *clientv3.LeaseKeepAliveResponse, stop chan error) {
+	defer cli.Close()
+
 	for {
 		select {
 		case err := <-stop:
 			if err != nil {
 				log.Println(err)
 			}
-			return err
+			if _, err := cli.Revoke(context.Background(), leaseId); err != nil {
+				log.Printf("revoke lease failed: %v", err)
+			}
+			return
 		case <-cli.Ctx().Done():
 			log.Println("service closed")
-			return nil
+			return
 		case _, ok := <-ch:
 			// 监听租约
 			if !ok {
 				log.Println("keep alive channel closed")
-				_, err := cli.Revoke(context.Background(), leaseId)
-				return err
+				if _, err := cli.Revoke(context.Background(), leaseId); err != nil {
+					log.Printf("revoke lease failed: %v", err)
+				}
+				return
 			}
 			//log.Printf("Recv reply from service: %s/%s, ttl:%d", service, addr, resp.TTL)
 		}
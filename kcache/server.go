@@ -6,6 +6,7 @@ package kcache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"kcache/kcache/consistenthash"
 	pb "kcache/kcache/kcachepb"
@@ -17,7 +18,6 @@ import (
 	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
-	"go.etcd.io/etcd/client/v3/naming/endpoints"
 	"google.golang.org/grpc"
 	//"google.golang.org/grpc/peer"
 )
@@ -39,19 +39,26 @@ var (
 )
 
 // Server 和 Group 是解耦合的 所以Server要自己实现并发控制
+// 一个节点只需创建一个Server 任意多个Group通过 WithPicker 共用它
 type Server struct {
 	pb.UnimplementedKCacheServer
 
+	inst       *Instance  // 处理请求时从inst中查找Group 并使用inst的配置
 	addr       string     // format: ip:port
 	status     bool       // true: running false: stop
-	stopSignal chan error // 通知registry revoke服务
+	stopSignal chan error // 关闭后通知registry revoke服务 并停止监听节点变化
+	grpcServer *grpc.Server
 	mu         sync.Mutex
 	consHash   *consistenthash.Consistency
 	clients    map[string]*client
 }
 
-// NewServer 创建cache的svr 若addr为空 则使用defaultAddr
+// NewServer 为默认的Instance创建cache的svr 若addr为空 则使用defaultAddr
 func NewServer(addr string) (*Server, error) {
+	return newServer(defaultInstance, addr)
+}
+
+func newServer(inst *Instance, addr string) (*Server, error) {
 	if addr == "" {
 		addr = defaultAddr
 	}
@@ -59,7 +66,7 @@ func NewServer(addr string) (*Server, error) {
 	if !validPeerAddr(addr) {
 		return nil, fmt.Errorf("invalid addr %s, it should be x.x.x.x:port", addr)
 	}
-	return &Server{addr: addr}, nil
+	return &Server{inst: inst, addr: addr}, nil
 }
 
 // Get 实现PeanutCache service的Get接口
@@ -73,26 +80,244 @@ func (s *Server) Get(ctx context.Context, in *pb.GetRequest) (*pb.GetResponse, e
 	group, key := in.GetGroup(), in.GetKey()
 	resp := &pb.GetResponse{}
 
-	log.Printf("[kcache_svr %s] Recv RPC Request - (%s)/(%s)", s.addr, group, key)
+	log.Printf("[kcache_svr %s] Recv RPC Request - (%s)/(%s) request id: %q", s.addr, group, key, RequestIDFromContext(ctx))
 	if key == "" {
 		return resp, fmt.Errorf("key required")
 	}
 
-	g := GetGroup(group)
+	g := s.inst.GetGroup(group)
 	if g == nil {
 		return resp, fmt.Errorf("group not found")
 	}
+	g.stats.serverRequests.Add(1)
 
-	view, err := g.Get(key)
+	// ctx带有调用方的截止时间 调用方放弃后本节点的加载随之取消
+	view, err := g.GetContext(ctx, key)
 	if err != nil {
 		return resp, err
 	}
 
-	resp.Value = view.ByteSlice()
+	return newGetResponse(view), nil
+}
+
+// newGetResponse 将ByteView及其元数据转换为GetResponse
+func newGetResponse(view ByteView) *pb.GetResponse {
+	return &pb.GetResponse{
+		Value:   view.ByteSlice(),
+		Ttl:     view.ttl().Milliseconds(),
+		Etag:    view.etag,
+		NoCache: view.noCache,
+		Tags:    view.tags,
+		Version: view.version,
+	}
+}
+
+// Delete 实现KCache service的Delete接口
+// 只删除本节点的cache与hotcache 不再向其他节点转发
+func (s *Server) Delete(ctx context.Context, in *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	resp := &pb.DeleteResponse{}
+
+	log.Printf("[kcache_svr %s] Recv RPC Delete - (%s)/(%s)", s.addr, group, key)
+	if key == "" {
+		return resp, fmt.Errorf("key required")
+	}
+
+	g := s.inst.GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	g.stats.serverRequests.Add(1)
+
+	resp.Deleted = g.removeLocally(key)
+	return resp, nil
+}
+
+// GetMulti 实现KCache service的GetMulti接口
+func (s *Server) GetMulti(ctx context.Context, in *pb.GetMultiRequest) (*pb.GetMultiResponse, error) {
+	group, keys := in.GetGroup(), in.GetKeys()
+	resp := &pb.GetMultiResponse{}
+
+	log.Printf("[kcache_svr %s] Recv RPC GetMulti - (%s)/(%d keys)", s.addr, group, len(keys))
+
+	g := s.inst.GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	g.stats.serverRequests.Add(1)
+
+	values, errs := g.GetMultiContext(ctx, keys)
+	resp.Values = make(map[string]*pb.GetResponse, len(values))
+	for key, view := range values {
+		resp.Values[key] = newGetResponse(view)
+	}
+	resp.Errors = make(map[string]string, len(errs))
+	for key, err := range errs {
+		resp.Errors[key] = err.Error()
+	}
 	return resp, nil
 }
 
-// Start 启动cache服务
+// Set 实现KCache service的Set接口
+// 请求由负责该key的节点处理 配置了Writer时先写入数据源 再写入其cache
+// 其他节点持有lease加载后的写回不写入数据源 见 Filler
+func (s *Server) Set(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	resp := &pb.SetResponse{}
+
+	log.Printf("[kcache_svr %s] Recv RPC Set - (%s)/(%s)", s.addr, group, key)
+	if key == "" {
+		return resp, fmt.Errorf("key required")
+	}
+
+	g := s.inst.GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	g.stats.serverRequests.Add(1)
+
+	ttl := time.Duration(in.GetTtl()) * time.Millisecond
+	if ttl == 0 {
+		ttl = g.ttl
+	}
+	if in.GetFill() {
+		g.fillBack(key, in.GetValue(), ttl, in.GetTags())
+		return resp, nil
+	}
+	return resp, g.setLocally(ctx, key, in.GetValue(), ttl, in.GetTags())
+}
+
+// CompareAndSwap 实现KCache service的CompareAndSwap接口
+// 请求由负责该key的节点处理 比较与写入在key的锁内完成
+func (s *Server) CompareAndSwap(ctx context.Context, in *pb.CompareAndSwapRequest) (*pb.CompareAndSwapResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	resp := &pb.CompareAndSwapResponse{}
+
+	log.Printf("[kcache_svr %s] Recv RPC CompareAndSwap - (%s)/(%s)", s.addr, group, key)
+	if key == "" {
+		return resp, fmt.Errorf("key required")
+	}
+
+	g := s.inst.GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	g.stats.serverRequests.Add(1)
+
+	ttl := time.Duration(in.GetTtl()) * time.Millisecond
+	if ttl == 0 {
+		ttl = g.ttl
+	}
+	version, err := g.compareAndSwapLocally(ctx, key, in.GetExpected(), in.GetValue(), ttl, in.GetTags())
+	switch {
+	case err == nil:
+		resp.Swapped = true
+	case errors.Is(err, ErrVersionMismatch):
+	default:
+		return resp, err
+	}
+	resp.Version = version
+	return resp, nil
+}
+
+// Incr 实现KCache service的Incr接口
+// 请求由负责该key的节点处理 增减在key的锁内完成
+func (s *Server) Incr(ctx context.Context, in *pb.IncrRequest) (*pb.IncrResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	resp := &pb.IncrResponse{}
+
+	log.Printf("[kcache_svr %s] Recv RPC Incr - (%s)/(%s)", s.addr, group, key)
+	if key == "" {
+		return resp, fmt.Errorf("key required")
+	}
+
+	g := s.inst.GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	g.stats.serverRequests.Add(1)
+
+	ttl := time.Duration(in.GetTtl()) * time.Millisecond
+	if ttl == 0 {
+		ttl = g.ttl
+	}
+	n, err := g.incrLocally(key, in.GetDelta(), ttl)
+	if err != nil {
+		return resp, err
+	}
+	resp.Value = n
+	return resp, nil
+}
+
+// Lease 实现KCache service的Lease接口
+// 其他节点持有lease时 等待其释放或过期后返回 等待时间不超过调用方的截止时间
+// no_wait时不等待 可以一次为多个key申请lease
+func (s *Server) Lease(ctx context.Context, in *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+	group, key, keys := in.GetGroup(), in.GetKey(), in.GetKeys()
+	resp := &pb.LeaseResponse{}
+
+	log.Printf("[kcache_svr %s] Recv RPC Lease - (%s)/(%s) %d keys", s.addr, group, key, len(keys))
+	if key == "" && len(keys) == 0 {
+		return resp, fmt.Errorf("key required")
+	}
+
+	g := s.inst.GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	g.stats.serverRequests.Add(1)
+
+	if id := in.GetRelease(); id != 0 {
+		g.leases.release(key, id)
+		return resp, nil
+	}
+	ttl := time.Duration(in.GetTtl()) * time.Millisecond
+	if ttl <= 0 {
+		ttl = defaultFillLease
+	}
+	if in.GetNoWait() {
+		if key != "" {
+			keys = append(keys, key)
+		}
+		resp.Ids = make(map[string]uint64, len(keys))
+		for _, key := range keys {
+			if id := g.leases.tryAcquire(key, ttl); id != 0 {
+				resp.Ids[key] = id
+			}
+		}
+		resp.Id = resp.Ids[key]
+	} else {
+		resp.Id = g.leases.acquire(ctx, key, ttl)
+	}
+	resp.Granted = resp.Id != 0
+	return resp, nil
+}
+
+// Invalidate 实现KCache service的Invalidate接口
+// 只删除本节点的cache与hotcache 不再向其他节点转发
+func (s *Server) Invalidate(ctx context.Context, in *pb.InvalidateRequest) (*pb.InvalidateResponse, error) {
+	group, tag, prefix := in.GetGroup(), in.GetTag(), in.GetPrefix()
+	resp := &pb.InvalidateResponse{}
+
+	log.Printf("[kcache_svr %s] Recv RPC Invalidate - (%s) tag: %q prefix: %q", s.addr, group, tag, prefix)
+
+	g := s.inst.GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	g.stats.serverRequests.Add(1)
+
+	n, err := g.invalidateLocally(tag, prefix)
+	if err != nil {
+		return resp, err
+	}
+	resp.Removed = int64(n)
+	return resp, nil
+}
+
+// Start 启动cache服务 服务就绪后返回
+// 返回nil时 端口已开始监听 服务已注册至etcd 并已取得当前的节点列表
+// 之后grpc服务与节点变化的监听都在后台运行 直到Stop
 func (s *Server) Start() error {
 	s.mu.Lock()
 	if s.status == true {
@@ -100,52 +325,60 @@ func (s *Server) Start() error {
 		return fmt.Errorf("server already started")
 	}
 	// -----------------启动服务----------------------
-	// 1. 设置status为true 表示服务器已在运行
-	// 2. 初始化stop channal,这用于通知registry stop keep alive
-	// 3. 初始化tcp socket并开始监听
-	// 4. 注册rpc服务至grpc 这样grpc收到request可以分发给server处理
-	// 5. 将自己的服务名/Host地址注册至etcd 这样client可以通过etcd
+	// 1. 初始化tcp socket并开始监听
+	// 2. 注册rpc服务至grpc 这样grpc收到request可以分发给server处理
+	// 3. 将自己的服务名/Host地址注册至etcd 这样client可以通过etcd
 	//    获取服务Host地址 从而进行通信。这样的好处是client只需知道服务名
 	//    以及etcd的Host即可获取对应服务IP 无需写死至client代码中
+	// 4. 设置status为true 表示服务器已在运行
+	// 5. 取得当前的节点列表 并在后台监听节点的上下线
 	// ----------------------------------------------
-	s.status = true
-	s.stopSignal = make(chan error)
-
-	log.Println("Kcache is running at", s.addr)
-
 	port := strings.Split(s.addr, ":")[1]
 	lis, err := net.Listen("tcp", ":"+port)
 	if err != nil {
+		s.mu.Unlock()
 		return fmt.Errorf("failed to listen: %v", err)
 	}
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(requestIDInterceptor))
 	pb.RegisterKCacheServer(grpcServer, s)
 
-	s.mu.Unlock()
+	stopSignal := make(chan error)
 	//****************
 	// 注册服务至etcd
 	//****************
-	go func() {
-		err := registry.Register("kcache", s.addr, s.stopSignal)
-		if err != nil {
-			log.Fatalf(err.Error())
-		}
+	if err := registry.Register(s.inst.config.Etcd, s.inst.config.Service, s.addr, stopSignal); err != nil {
+		s.mu.Unlock()
+		lis.Close()
+		return err
+	}
 
-		close(s.stopSignal)
+	s.status = true
+	s.stopSignal = stopSignal
+	s.grpcServer = grpcServer
+	s.mu.Unlock()
 
-		err = lis.Close()
-		if err != nil {
-			log.Fatalf(err.Error())
+	go func() {
+		// Serve将不会return 除非服务stop或者抛出error
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Printf("[%s] failed to serve: %v", s.addr, err)
 		}
-		log.Printf("[%s] Revoke service and close tcp socket ok.", s.addr)
+		log.Printf("[%s] Stop serving and close tcp socket ok.", s.addr)
 	}()
 
-	go s.UpdatePeers()
-
-	//log.Printf("[%s] register service ok\n", s.addr)
-	if err := grpcServer.Serve(lis); s.status && err != nil {
-		return fmt.Errorf("failed to serve: %v", err)
+	cli, err := clientv3.New(s.inst.config.Etcd)
+	if err != nil {
+		s.Stop()
+		return fmt.Errorf("create etcd client failed: %v", err)
 	}
+	rev, err := s.refreshPeers(cli)
+	if err != nil {
+		cli.Close()
+		s.Stop()
+		return err
+	}
+	go s.watchPeers(cli, rev, stopSignal)
+
+	log.Println("Kcache is running at", s.addr)
 	return nil
 }
 
@@ -154,49 +387,97 @@ func (s *Server) Start() error {
 // 注意: 此操作是*覆写*操作！
 // 注意: peersIP必须满足 x.x.x.x:port的格式
 func (s *Server) SetPeers(peersAddr ...string) {
+	s.setPeers(peersAddr...)
+
+	// 哈希环已变化 各Group移除不再由本节点负责的key
+	for _, g := range s.inst.servedBy(s) {
+		g.purgeMoved()
+	}
+}
+
+func (s *Server) setPeers(peersAddr ...string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.consHash = consistenthash.New(defaultReplicas, nil)
+	s.consHash = consistenthash.New(s.inst.config.Replicas, nil)
 	s.consHash.Register(peersAddr...)
-	s.clients = make(map[string]*client)
+	// 仍然在线的节点复用原有的client及其连接
+	clients := make(map[string]*client)
 	for idx, peerAddr := range peersAddr {
 		if !validPeerAddr(peerAddr) {
 			panic(fmt.Sprintf("[peer %s] invalid address format, it should be x.x.x.x:port", peerAddr))
 		}
-		service := fmt.Sprintf("kcache/%s", peerAddr)
 		fmt.Print(idx)
 		fmt.Println(" " + peerAddr)
-		s.clients[peerAddr] = NewClient(service)
+		if c, ok := s.clients[peerAddr]; ok {
+			clients[peerAddr] = c
+			continue
+		}
+		service := fmt.Sprintf("%s/%s", s.inst.config.Service, peerAddr)
+		clients[peerAddr] = newClient(service, s.inst.config)
+	}
+	// 关闭已下线节点的连接
+	for peerAddr, c := range s.clients {
+		if _, ok := clients[peerAddr]; !ok {
+			c.close()
+		}
 	}
+	s.clients = clients
 }
 
-func (s *Server) UpdatePeers() {
-	c, _ := clientv3.New(defaultEtcdConfig)
+// refreshPeers 从etcd取得当前的全部节点 覆写至Server 返回读取时etcd的revision
+func (s *Server) refreshPeers(c *clientv3.Client) (int64, error) {
+	resp, err := c.Get(c.Ctx(), s.inst.config.Service+"/", clientv3.WithPrefix())
+	if err != nil {
+		return 0, fmt.Errorf("list peers failed: %v", err)
+	}
+
+	keys := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		keys = append(keys, strings.Split(string(kv.Key), "/")[1])
+	}
+
+	//表示拆开切片
+	s.SetPeers(keys...)
+	return resp.Header.Revision, nil
+}
+
+// watchPeers 从rev之后监听节点的上下线 每次变化都重新取得全部节点
+// stop被关闭后退出 并关闭c
+func (s *Server) watchPeers(c *clientv3.Client, rev int64, stop <-chan error) {
 	defer c.Close()
 
-	for {
-		watchChan := c.Watch(context.Background(), "kcache", clientv3.WithPrefix())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for ctx.Err() == nil {
+		watchChan := c.Watch(ctx, s.inst.config.Service+"/", clientv3.WithPrefix(), clientv3.WithRev(rev+1))
 		for watchResp := range watchChan {
+			if watchResp.Err() != nil {
+				// 例如rev已被压缩 重新取得全部节点后从新的revision开始监听
+				log.Printf("[%s] watch peers: %v", s.addr, watchResp.Err())
+				if latest, err := s.refreshPeers(c); err == nil {
+					rev = latest
+				}
+				break
+			}
+			rev = watchResp.Header.Revision
 			for _, ev := range watchResp.Events {
 				if ev.Type == clientv3.EventTypePut || ev.Type == clientv3.EventTypeDelete {
 					//log.Printf("%s %q %q\n", ev.Type, ev.Kv.Key, ev.Kv.Value)
-
-					em, _ := endpoints.NewManager(c, "kcache")
-					mp, _ := em.List(c.Ctx())
-
-					keys := make([]string, 0, len(mp))
-					for k := range mp {
-						keys = append(keys, strings.Split(k, "/")[1])
+					if latest, err := s.refreshPeers(c); err == nil {
+						rev = max(rev, latest)
+					} else {
+						log.Printf("[%s] %v", s.addr, err)
 					}
-
-					//表示拆开切片
-					s.SetPeers(keys...)
+					break
 				}
 			}
 		}
-
-		//s.SetPeers()
 	}
 }
 
@@ -206,14 +487,48 @@ func (s *Server) Pick(key string) (Fetcher, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.consHash == nil {
+		return nil, false
+	}
 	peerAddr := s.consHash.GetPeer(key)
 	// Pick itself
 	if peerAddr == s.addr {
 		log.Printf("ooh! pick myself, I am %s\n", s.addr)
 		return nil, false
 	}
+	// 哈希环为空或节点已被移除 与owns一致视为本节点负责
+	c, ok := s.clients[peerAddr]
+	if peerAddr == "" || !ok {
+		return nil, false
+	}
 	log.Printf("[cache %s] pick remote peer: %s\n", s.addr, peerAddr)
-	return s.clients[peerAddr], true
+	return c, true
+}
+
+// Peers 返回除自身外的全部节点
+func (s *Server) Peers() []Fetcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make([]Fetcher, 0, len(s.clients))
+	for addr, c := range s.clients {
+		if addr != s.addr {
+			peers = append(peers, c)
+		}
+	}
+	return peers
+}
+
+// owns 判断key是否由本节点负责 尚未获知其他节点时视为本节点负责
+func (s *Server) owns(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.consHash == nil {
+		return true
+	}
+	peerAddr := s.consHash.GetPeer(key)
+	return peerAddr == "" || peerAddr == s.addr
 }
 
 // Stop 停止server运行 如果server没有运行 这将是一个no-op
@@ -223,9 +538,13 @@ func (s *Server) Stop() {
 		s.mu.Unlock()
 		return
 	}
-	s.stopSignal <- nil // 发送停止keepalive信号
+	close(s.stopSignal) // 通知停止keepalive并撤销服务
+	s.grpcServer.Stop() // 关闭tcp socket与现有的连接
 	s.status = false    // 设置server运行状态为stop
-	s.clients = nil     // 清空一致性哈希信息 有助于垃圾回收
+	for _, c := range s.clients {
+		c.close()
+	}
+	s.clients = nil // 清空一致性哈希信息 有助于垃圾回收
 	s.consHash = nil
 	s.mu.Unlock()
 }
@@ -0,0 +1,40 @@
+package kcache
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestPickEmptyRing 哈希环为空时 Pick视为本节点负责 不返回nil的Fetcher
+func TestPickEmptyRing(t *testing.T) {
+	s, err := newServer(defaultInstance, "127.0.0.1:9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := s.Pick("key"); ok || f != nil {
+		t.Fatalf("Pick before peers = %v, %v", f, ok)
+	}
+	s.setPeers()
+	if f, ok := s.Pick("key"); ok || f != nil {
+		t.Fatalf("Pick on an empty ring = %v, %v", f, ok)
+	}
+	if !s.owns("key") {
+		t.Fatalf("owns on an empty ring = false")
+	}
+
+	s.setPeers("127.0.0.1:9999", "127.0.0.1:9998")
+	remote := 0
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		f, ok := s.Pick(key)
+		if ok != !s.owns(key) || ok != (f != nil) {
+			t.Fatalf("Pick(%s) = %v, %v, owns = %v", key, f, ok, s.owns(key))
+		}
+		if ok {
+			remote++
+		}
+	}
+	if remote == 0 || remote == 100 {
+		t.Fatalf("%d of 100 keys picked remote", remote)
+	}
+}
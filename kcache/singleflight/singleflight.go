@@ -1,13 +1,134 @@
 package singleflight
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"time"
 )
 
 type packet struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done      chan struct{} // fn执行完毕后关闭
+	val       interface{}
+	err       error
+	panic     interface{} // fn发生panic时的值 由仍在等待的调用者重新抛出
+	waiters   int         // 仍在等待结果的调用者个数
+	deadlines []time.Time // 仍在等待的调用者的截止时间
+	unbounded int         // 仍在等待且没有截止时间的调用者个数
+	ctx       *flightContext
+}
+
+// join 记录一个等待者 并按全部等待者重新计算fn的截止时间
+func (p *packet) join(deadline time.Time, ok bool) {
+	p.waiters++
+	if ok {
+		p.deadlines = append(p.deadlines, deadline)
+	} else {
+		p.unbounded++
+	}
+	p.updateDeadline()
+}
+
+// leave 移除一个等待者 返回是否已没有等待者
+func (p *packet) leave(deadline time.Time, ok bool) bool {
+	p.waiters--
+	if ok {
+		for i, d := range p.deadlines {
+			if d.Equal(deadline) {
+				p.deadlines = append(p.deadlines[:i], p.deadlines[i+1:]...)
+				break
+			}
+		}
+	} else {
+		p.unbounded--
+	}
+	if p.waiters == 0 {
+		return true
+	}
+	p.updateDeadline()
+	return false
+}
+
+// updateDeadline fn的截止时间取仍在等待的调用者中最晚的一个 有调用者没有截止时间时fn也没有
+func (p *packet) updateDeadline() {
+	if p.unbounded > 0 || len(p.deadlines) == 0 {
+		p.ctx.setDeadline(time.Time{}, false)
+		return
+	}
+	latest := p.deadlines[0]
+	for _, d := range p.deadlines[1:] {
+		if d.After(latest) {
+			latest = d
+		}
+	}
+	p.ctx.setDeadline(latest, true)
+}
+
+// flightContext 是fn使用的ctx 保留第一个调用者ctx中的值
+// 截止时间随等待者的加入与离开而变化 到期后以 context.DeadlineExceeded 取消
+type flightContext struct {
+	context.Context
+	cancel context.CancelCauseFunc
+
+	mu       sync.Mutex
+	deadline time.Time
+	ok       bool
+	timer    *time.Timer
+}
+
+func newFlightContext(parent context.Context) *flightContext {
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(parent))
+	return &flightContext{Context: ctx, cancel: cancel}
+}
+
+func (c *flightContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadline, c.ok
+}
+
+func (c *flightContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && errors.Is(context.Cause(c.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func (c *flightContext) setDeadline(deadline time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ok == ok && c.deadline.Equal(deadline) {
+		return
+	}
+	c.deadline, c.ok = deadline, ok
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if ok {
+		c.timer = time.AfterFunc(time.Until(deadline), func() {
+			c.mu.Lock()
+			// 截止时间已被推迟时不取消
+			expired := c.ok && !c.deadline.After(deadline)
+			c.mu.Unlock()
+			if expired {
+				c.cancel(context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+// stop 取消ctx并停止计时
+func (c *flightContext) stop() {
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.mu.Unlock()
+	c.cancel(context.Canceled)
 }
 
 type Flight struct {
@@ -15,30 +136,77 @@ type Flight struct {
 	flight map[string]*packet
 }
 
+// Fly 执行fn 同一时刻对同一key的多次调用只会执行一次fn 并共享其结果
 func (f *Flight) Fly(key string, fn func() (interface{}, error)) (interface{}, error) {
+	val, err, _ := f.Do(key, fn)
+	return val, err
+}
+
+// Do 与 Fly 相同 shared 指明本次调用是否复用了其他调用的结果
+func (f *Flight) Do(key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
+	return f.DoContext(context.Background(), key, func(context.Context) (interface{}, error) {
+		return fn()
+	})
+}
+
+// DoContext 与 Do 相同 但等待结果时ctx被取消会立即返回ctx.Err()
+// fn在单独的goroutine中执行 使用的ctx保留第一个调用者ctx中的值 但不随任何一个调用者取消
+// fn的ctx的截止时间是仍在等待的调用者中最晚的截止时间 有调用者没有截止时间时fn也没有
+// 只有全部调用者都已放弃时 fn的ctx才会被取消 之后对同一key的调用将重新执行fn
+func (f *Flight) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (val interface{}, err error, shared bool) {
 	f.mu.Lock()
 	if f.flight == nil {
 		f.flight = make(map[string]*packet)
 	}
 
-	if p, ok := f.flight[key]; ok {
-		f.mu.Unlock()
-		p.wg.Wait() //后入的会进入这个if，然后阻塞在wait
-		return p.val, p.err
+	//后入的会复用先入者的packet 先入的会解决这个请求
+	p, shared := f.flight[key]
+	if !shared {
+		p = &packet{done: make(chan struct{}), ctx: newFlightContext(ctx)}
+		f.flight[key] = p
+	}
+	deadline, ok := ctx.Deadline()
+	p.join(deadline, ok)
+	if !shared {
+		go f.run(key, p, fn)
 	}
-
-	//先入的会解决这个请求
-	p := new(packet)
-	p.wg.Add(1)
-	f.flight[key] = p
 	f.mu.Unlock()
 
-	p.val, p.err = fn()
-	p.wg.Done()
+	select {
+	case <-p.done:
+		if p.panic != nil {
+			panic(p.panic)
+		}
+		return p.val, p.err, shared
+	case <-ctx.Done():
+		f.mu.Lock()
+		if p.leave(deadline, ok) {
+			// 没有调用者在等待 中断fn 之后的调用不再复用它
+			if f.flight[key] == p {
+				delete(f.flight, key)
+			}
+			p.ctx.stop()
+		}
+		f.mu.Unlock()
+		return nil, ctx.Err(), shared
+	}
+}
 
-	f.mu.Lock()
-	delete(f.flight, key) // 航班已完成
-	f.mu.Unlock()
+// run 执行fn并唤醒全部等待者
+func (f *Flight) run(key string, p *packet, fn func(ctx context.Context) (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.panic = r
+		}
+		p.ctx.stop()
+
+		f.mu.Lock()
+		if f.flight[key] == p {
+			delete(f.flight, key) // 航班已完成
+		}
+		f.mu.Unlock()
+		close(p.done)
+	}()
 
-	return p.val, p.err
+	p.val, p.err = fn(p.ctx)
 }
@@ -0,0 +1,233 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var f Flight
+	v, err := f.Fly("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil {
+		t.Fatalf("Fly = %v, %v", v, err)
+	}
+}
+
+func TestDoDedup(t *testing.T) {
+	var f Flight
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(context.Context) (interface{}, error) {
+		calls.Add(1)
+		<-release
+		return "bar", nil
+	}
+
+	results := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, _, shared := f.DoContext(context.Background(), "key", fn)
+			results <- shared
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	shared := 0
+	for i := 0; i < 10; i++ {
+		if <-results {
+			shared++
+		}
+	}
+	if calls.Load() != 1 || shared != 9 {
+		t.Fatalf("calls=%d shared=%d, want 1 and 9", calls.Load(), shared)
+	}
+}
+
+// TestLeaderCancel 第一个调用者取消后 其余调用者仍能得到结果
+func TestLeaderCancel(t *testing.T) {
+	var f Flight
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		select {
+		case <-release:
+			return "bar", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err, _ := f.DoContext(ctx, "key", fn)
+		leader <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	waiter := make(chan interface{})
+	go func() {
+		v, _, _ := f.DoContext(context.Background(), "key", fn)
+		waiter <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Fatalf("leader got %v", err)
+	}
+	close(release)
+	if v := <-waiter; v != "bar" {
+		t.Fatalf("waiter got %v", v)
+	}
+}
+
+// TestAllCancel 全部调用者都放弃后 fn的ctx被取消 之后的调用重新执行fn
+func TestAllCancel(t *testing.T) {
+	var f Flight
+	stopped := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		f.DoContext(ctx, "key", func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			close(stopped)
+			return nil, ctx.Err()
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("fn was not cancelled")
+	}
+	v, err, shared := f.DoContext(context.Background(), "key", func(context.Context) (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil || shared {
+		t.Fatalf("DoContext = %v, %v, %v", v, err, shared)
+	}
+}
+
+// TestDeadline fn的ctx带有调用者的截止时间 并在到期后以DeadlineExceeded取消
+func TestDeadline(t *testing.T) {
+	var f Flight
+	want := time.Now().Add(50 * time.Millisecond)
+	ctx, cancel := context.WithDeadline(context.Background(), want)
+	defer cancel()
+
+	_, err, _ := f.DoContext(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		if d, ok := ctx.Deadline(); !ok || !d.Equal(want) {
+			t.Errorf("deadline = %v, %v, want %v", d, ok, want)
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+// TestDeadlineLatest fn的截止时间是仍在等待的调用者中最晚的一个 该调用者离开后随之提前
+func TestDeadlineLatest(t *testing.T) {
+	var f Flight
+	now := time.Now()
+	early, late := now.Add(time.Hour), now.Add(2*time.Hour)
+	started := make(chan context.Context, 1)
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		started <- ctx
+		<-release
+		return "bar", nil
+	}
+
+	ctx1, cancel1 := context.WithDeadline(context.Background(), early)
+	defer cancel1()
+	done1 := make(chan struct{})
+	go func() {
+		f.DoContext(ctx1, "key", fn)
+		close(done1)
+	}()
+	fctx := <-started
+	if d, _ := fctx.Deadline(); !d.Equal(early) {
+		t.Fatalf("deadline = %v, want %v", d, early)
+	}
+
+	ctx2, cancel2 := context.WithDeadline(context.Background(), late)
+	done2 := make(chan struct{})
+	go func() {
+		f.DoContext(ctx2, "key", fn)
+		close(done2)
+	}()
+	waitDeadline(t, fctx, late, true)
+
+	done3 := make(chan struct{})
+	go func() {
+		f.DoContext(context.Background(), "key", fn)
+		close(done3)
+	}()
+	waitDeadline(t, fctx, time.Time{}, false)
+
+	cancel2()
+	<-done2
+	close(release)
+	<-done1
+	<-done3
+}
+
+// TestDeadlineShrink 截止时间最晚的调用者离开后 fn的截止时间提前
+func TestDeadlineShrink(t *testing.T) {
+	var f Flight
+	now := time.Now()
+	early, late := now.Add(time.Hour), now.Add(2*time.Hour)
+	started := make(chan context.Context, 1)
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		started <- ctx
+		<-release
+		return "bar", nil
+	}
+
+	ctx1, cancel1 := context.WithDeadline(context.Background(), late)
+	done1 := make(chan struct{})
+	go func() {
+		f.DoContext(ctx1, "key", fn)
+		close(done1)
+	}()
+	fctx := <-started
+
+	ctx2, cancel2 := context.WithDeadline(context.Background(), early)
+	defer cancel2()
+	done2 := make(chan struct{})
+	go func() {
+		f.DoContext(ctx2, "key", fn)
+		close(done2)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	waitDeadline(t, fctx, late, true)
+
+	cancel1()
+	<-done1
+	waitDeadline(t, fctx, early, true)
+	close(release)
+	<-done2
+}
+
+// waitDeadline 等待ctx的截止时间变为want
+func waitDeadline(t *testing.T, ctx context.Context, want time.Time, wantOK bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if d, ok := ctx.Deadline(); ok == wantOK && d.Equal(want) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	d, ok := ctx.Deadline()
+	t.Fatalf("deadline = %v, %v, want %v, %v", d, ok, want, wantOK)
+}
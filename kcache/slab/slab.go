@@ -0,0 +1,186 @@
+package slab
+
+// slab 包实现了按大小分级的内存池
+// 内存以页为单位向runtime申请 每页按所属级别切分为等长的块(chunk)
+// 数据存放在能容纳它的最小级别的块中 释放后块回到所属级别的空闲链表等待复用
+// 大量小对象因此集中在少量大的[]byte页中 可以显著降低GC的扫描压力
+
+import (
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+const (
+	minChunkSize = 64      // 最小级别的块大小
+	maxChunkSize = 1 << 20 // 最大级别的块大小 更大的数据不经过内存池
+	pageSize     = 1 << 20 // 每次向runtime申请的页大小
+)
+
+// class 是一个大小级别 管理该级别所有的块
+type class struct {
+	mu        sync.Mutex
+	size      int      // 块大小
+	free      [][]byte // 空闲块
+	pages     [][]byte // 已申请的页 按地址升序排列 用于判断块是否属于该级别
+	chunks    int      // 已切分出的块总数
+	inUse     int      // 正在使用的块数
+	requested int64    // 正在使用的块中实际存放的数据字节数
+}
+
+// Pool 是按大小分级的内存池 可以被多个goroutine并发使用
+type Pool struct {
+	classes []*class
+}
+
+// New 创建一个内存池 块大小从64B起按2倍递增至1MB
+func New() *Pool {
+	p := &Pool{}
+	for size := minChunkSize; size <= maxChunkSize; size <<= 1 {
+		p.classes = append(p.classes, &class{size: size})
+	}
+	return p
+}
+
+// classFor 返回能容纳n字节的最小级别 n超出最大级别时返回nil
+func (p *Pool) classFor(n int) *class {
+	for _, c := range p.classes {
+		if n <= c.size {
+			return c
+		}
+	}
+	return nil
+}
+
+// Alloc 分配长度为n的[]byte 其容量为所属级别的块大小
+// 超出最大级别的请求直接向runtime申请
+func (p *Pool) Alloc(n int) []byte {
+	c := p.classFor(n)
+	if c == nil {
+		return make([]byte, n)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.free) == 0 {
+		c.grow()
+	}
+	chunk := c.free[len(c.free)-1]
+	c.free = c.free[:len(c.free)-1]
+	c.inUse++
+	c.requested += int64(n)
+	return chunk[:n]
+}
+
+// Copy 从内存池分配一块内存 并将b复制进去
+func (p *Pool) Copy(b []byte) []byte {
+	c := p.Alloc(len(b))
+	copy(c, b)
+	return c
+}
+
+// Free 将Alloc分配的内存归还内存池 之后不应再使用b
+// 不是由内存池分配的b将被忽略 同一块内存不应被归还两次
+func (p *Pool) Free(b []byte) {
+	c := p.classFor(cap(b))
+	if c == nil || c.size != cap(b) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.owns(b) {
+		return
+	}
+	c.free = append(c.free, b[:c.size])
+	c.inUse--
+	c.requested -= int64(len(b))
+}
+
+// grow 申请新的一页 并切分为该级别的块
+func (c *class) grow() {
+	size := max(pageSize, c.size)
+	page := make([]byte, size)
+	for off := 0; off+c.size <= size; off += c.size {
+		// 限定容量 防止append越界写到相邻的块
+		c.free = append(c.free, page[off:off+c.size:off+c.size])
+		c.chunks++
+	}
+
+	i := sort.Search(len(c.pages), func(i int) bool { return addr(c.pages[i]) > addr(page) })
+	c.pages = append(c.pages, nil)
+	copy(c.pages[i+1:], c.pages[i:])
+	c.pages[i] = page
+}
+
+// owns 判断b是否是该级别某一页中的一个块 调用时需持有锁
+func (c *class) owns(b []byte) bool {
+	p := addr(b)
+	// 第一个起始地址大于p的页之前的那一页 是唯一可能包含p的页
+	i := sort.Search(len(c.pages), func(i int) bool { return addr(c.pages[i]) > p })
+	if i == 0 {
+		return false
+	}
+	start := addr(c.pages[i-1])
+	return p < start+uintptr(len(c.pages[i-1])) && (p-start)%uintptr(c.size) == 0
+}
+
+// addr 返回b底层数组的起始地址 b的容量不能为0
+func addr(b []byte) uintptr {
+	return uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+}
+
+// ClassStats 描述一个大小级别的使用情况
+type ClassStats struct {
+	ChunkSize      int   // 块大小
+	Pages          int   // 已申请的页数
+	Chunks         int   // 块总数
+	ChunksInUse    int   // 正在使用的块数
+	RequestedBytes int64 // 正在使用的块中实际存放的数据字节数
+}
+
+// Stats 描述内存池的使用情况与碎片率
+type Stats struct {
+	Classes        []ClassStats
+	TotalBytes     int64 // 已向runtime申请的内存
+	InUseBytes     int64 // 正在使用的块占用的内存
+	RequestedBytes int64 // 实际存放的数据字节数
+}
+
+// InternalFragmentation 返回内部碎片率 即已分配的块中未被数据使用的比例
+func (s Stats) InternalFragmentation() float64 {
+	if s.InUseBytes == 0 {
+		return 0
+	}
+	return 1 - float64(s.RequestedBytes)/float64(s.InUseBytes)
+}
+
+// ExternalFragmentation 返回外部碎片率 即已申请的内存中处于空闲的比例
+func (s Stats) ExternalFragmentation() float64 {
+	if s.TotalBytes == 0 {
+		return 0
+	}
+	return 1 - float64(s.InUseBytes)/float64(s.TotalBytes)
+}
+
+// Stats 返回内存池当前的使用情况
+func (p *Pool) Stats() Stats {
+	var s Stats
+	for _, c := range p.classes {
+		c.mu.Lock()
+		cs := ClassStats{
+			ChunkSize:      c.size,
+			Pages:          len(c.pages),
+			Chunks:         c.chunks,
+			ChunksInUse:    c.inUse,
+			RequestedBytes: c.requested,
+		}
+		c.mu.Unlock()
+
+		s.Classes = append(s.Classes, cs)
+		s.TotalBytes += int64(cs.Chunks) * int64(cs.ChunkSize)
+		s.InUseBytes += int64(cs.ChunksInUse) * int64(cs.ChunkSize)
+		s.RequestedBytes += cs.RequestedBytes
+	}
+	return s
+}
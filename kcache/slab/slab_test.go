@@ -0,0 +1,97 @@
+package slab
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAlloc(t *testing.T) {
+	p := New()
+	for _, tt := range []struct {
+		n, cap int
+	}{
+		{0, 64}, {1, 64}, {64, 64}, {65, 128}, {1000, 1024}, {1 << 20, 1 << 20},
+	} {
+		b := p.Alloc(tt.n)
+		if len(b) != tt.n || cap(b) != tt.cap {
+			t.Fatalf("Alloc(%d) len=%d cap=%d, want cap %d", tt.n, len(b), cap(b), tt.cap)
+		}
+	}
+
+	// 超出最大级别的请求不经过内存池
+	b := p.Alloc(1<<20 + 1)
+	if len(b) != 1<<20+1 {
+		t.Fatalf("large Alloc len=%d", len(b))
+	}
+	p.Free(b)
+	if s := p.Stats(); s.InUseBytes != 64*3+128+1024+1<<20 {
+		t.Fatalf("in use %d bytes", s.InUseBytes)
+	}
+}
+
+func TestFreeReuse(t *testing.T) {
+	p := New()
+	b := p.Copy([]byte("hello"))
+	if string(b) != "hello" {
+		t.Fatalf("Copy returned %q", b)
+	}
+	p.Free(b)
+	c := p.Alloc(10)
+	if &c[:1][0] != &b[:1][0] {
+		t.Fatalf("freed chunk was not reused")
+	}
+
+	s := p.Stats()
+	if s.Classes[0].ChunksInUse != 1 || s.RequestedBytes != 10 {
+		t.Fatalf("chunks in use %d, requested %d", s.Classes[0].ChunksInUse, s.RequestedBytes)
+	}
+	if s.Classes[0].Pages != 1 || s.TotalBytes != pageSize {
+		t.Fatalf("pages %d, total %d", s.Classes[0].Pages, s.TotalBytes)
+	}
+}
+
+func TestFragmentation(t *testing.T) {
+	p := New()
+	p.Alloc(48)
+	s := p.Stats()
+	if got := s.InternalFragmentation(); got != 0.25 {
+		t.Fatalf("internal fragmentation %v, want 0.25", got)
+	}
+	if got := s.ExternalFragmentation(); got != 1-64.0/pageSize {
+		t.Fatalf("external fragmentation %v", got)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	p := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				p.Free(p.Alloc(j % 4096))
+			}
+		}()
+	}
+	wg.Wait()
+	if s := p.Stats(); s.InUseBytes != 0 || s.RequestedBytes != 0 {
+		t.Fatalf("leaked %d bytes", s.InUseBytes)
+	}
+}
+
+func TestFreeForeign(t *testing.T) {
+	p := New()
+	p.Alloc(10)
+	// 容量恰好等于块大小 但不是由内存池分配的内存
+	p.Free(make([]byte, 10, 64))
+	if s := p.Stats(); s.Classes[0].ChunksInUse != 1 {
+		t.Fatalf("foreign slice changed chunks in use to %d", s.Classes[0].ChunksInUse)
+	}
+	// 其他级别分配的块同样被忽略
+	b := p.Alloc(100)
+	p.Free(b[64:64])
+	if s := p.Stats(); s.Classes[1].ChunksInUse != 1 {
+		t.Fatalf("misaligned slice changed chunks in use")
+	}
+}
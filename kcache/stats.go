@@ -0,0 +1,99 @@
+package kcache
+
+import "sync/atomic"
+
+// stats 模块统计Group及其cache/hotcache的访问情况
+// 所有计数器均为原子操作 不需要额外加锁
+
+// CacheStats 是cache或hotcache的统计信息快照
+type CacheStats struct {
+	Gets      int64 // 查询次数
+	Hits      int64 // 命中次数
+	Evictions int64 // 被移除的记录数 不包括被新值覆盖的记录
+	Items     int64 // 当前记录个数
+	Bytes     int64 // 当前占用的字节数
+}
+
+// HitRate 返回命中率
+func (s CacheStats) HitRate() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Gets)
+}
+
+// Stats 是Group的统计信息快照
+type Stats struct {
+	Gets           int64 // Get 调用次数
+	CacheHits      int64 // cache 命中次数
+	HotCacheHits   int64 // hotcache 命中次数
+	PeerLoads      int64 // 从远端节点成功取回的次数
+	PeerErrors     int64 // 从远端节点取回失败的次数
+	LocalLoads     int64 // 调用Retriever的次数
+	LocalLoadErrs  int64 // 调用Retriever失败的次数
+	FlightDedups   int64 // 被singleflight合并掉的加载次数
+	ServerRequests int64 // 处理其他节点请求的次数
+	Writes         int64 // 成功写入数据源的次数
+	WriteErrors    int64 // 写入数据源失败的次数 write-behind中为重试后仍失败的个数
+	LoadRejects    int64 // 被限流或熔断拒绝的加载次数
+	StaleServed    int64 // 加载被拒绝时返回过期旧值的次数
+
+	Cache    CacheStats
+	HotCache CacheStats
+}
+
+// groupStats 是Group的计数器
+type groupStats struct {
+	gets           atomic.Int64
+	cacheHits      atomic.Int64
+	hotCacheHits   atomic.Int64
+	peerLoads      atomic.Int64
+	peerErrors     atomic.Int64
+	localLoads     atomic.Int64
+	localLoadErrs  atomic.Int64
+	flightDedups   atomic.Int64
+	serverRequests atomic.Int64
+	writes         atomic.Int64
+	writeErrors    atomic.Int64
+	loadRejects    atomic.Int64
+	staleServed    atomic.Int64
+}
+
+// cacheStats 是cache的计数器
+type cacheStats struct {
+	gets      atomic.Int64
+	hits      atomic.Int64
+	evictions atomic.Int64
+}
+
+// stats 返回cache的统计信息快照
+func (c *cache) stats() CacheStats {
+	return CacheStats{
+		Gets:      c.counters.gets.Load(),
+		Hits:      c.counters.hits.Load(),
+		Evictions: c.counters.evictions.Load(),
+		Items:     int64(c.len()),
+		Bytes:     c.bytes(),
+	}
+}
+
+// Stats 返回Group的统计信息快照
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:           g.stats.gets.Load(),
+		CacheHits:      g.stats.cacheHits.Load(),
+		HotCacheHits:   g.stats.hotCacheHits.Load(),
+		PeerLoads:      g.stats.peerLoads.Load(),
+		PeerErrors:     g.stats.peerErrors.Load(),
+		LocalLoads:     g.stats.localLoads.Load(),
+		LocalLoadErrs:  g.stats.localLoadErrs.Load(),
+		FlightDedups:   g.stats.flightDedups.Load(),
+		ServerRequests: g.stats.serverRequests.Load(),
+		Writes:         g.stats.writes.Load(),
+		WriteErrors:    g.stats.writeErrors.Load(),
+		LoadRejects:    g.stats.loadRejects.Load(),
+		StaleServed:    g.stats.staleServed.Load(),
+		Cache:          g.cache.stats(),
+		HotCache:       g.hotcache.stats(),
+	}
+}
@@ -0,0 +1,62 @@
+package tinylfu
+
+// doorkeeper 模块实现了一个布隆过滤器
+// 只出现过一次的key只会记录在doorkeeper中 不会占用sketch的计数器
+// 这样大量一次性访问的长尾key不会污染sketch的频次统计
+
+const doorkeeperHashes = 3
+
+// doorkeeperBitsPerEntry 每个预计插入的key占用的bit数
+// 3个哈希函数时 每个key约10个bit 误判率约为1%
+const doorkeeperBitsPerEntry = 10
+
+type doorkeeper struct {
+	bits []uint64
+	mask uint64 // bit个数-1
+}
+
+// newDoorkeeper 创建可容纳insertions个key的过滤器 插入更多的key后误判率会迅速上升
+func newDoorkeeper(insertions int) *doorkeeper {
+	n := nextPowerOfTwo(uint64(insertions) * doorkeeperBitsPerEntry)
+	if n < 64 {
+		n = 64
+	}
+	return &doorkeeper{bits: make([]uint64, n/64), mask: n - 1}
+}
+
+// Add 记录hash 返回hash此前是否可能已存在
+func (d *doorkeeper) Add(hash uint64) bool {
+	existed := true
+	for i := uint64(0); i < doorkeeperHashes; i++ {
+		idx := d.index(hash, i)
+		if d.bits[idx/64]&(1<<(idx%64)) == 0 {
+			existed = false
+			d.bits[idx/64] |= 1 << (idx % 64)
+		}
+	}
+	return existed
+}
+
+// Has 判断hash是否可能已存在 存在一定的误判率 但不会漏判
+func (d *doorkeeper) Has(hash uint64) bool {
+	for i := uint64(0); i < doorkeeperHashes; i++ {
+		idx := d.index(hash, i)
+		if d.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Reset 清空过滤器
+func (d *doorkeeper) Reset() {
+	for i := range d.bits {
+		d.bits[i] = 0
+	}
+}
+
+// index 使用双重哈希 h1 + i*h2 派生出第i个下标
+func (d *doorkeeper) index(hash uint64, i uint64) uint64 {
+	h1, h2 := hash, (hash>>32)|(hash<<32)
+	return (h1 + i*h2) & d.mask
+}
@@ -0,0 +1,81 @@
+package tinylfu
+
+// sketch 模块实现了Count-Min Sketch 用于以极小的内存估算key的访问频次
+// 每个计数器只占4bit 最大计数为15 对于判断冷热已经足够
+
+const (
+	sketchDepth = 4  // 哈希函数个数(行数)
+	counterMax  = 15 // 4bit计数器上限
+)
+
+// 每行使用不同的种子 由同一个64位哈希值派生出各行的下标
+var sketchSeeds = [sketchDepth]uint64{
+	0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325,
+}
+
+// cmSketch 是4bit计数器的Count-Min Sketch
+type cmSketch struct {
+	rows [sketchDepth][]uint64 // 每个uint64存放16个4bit计数器
+	mask uint64                // 每行计数器个数-1
+}
+
+func newCMSketch(counters int) *cmSketch {
+	width := nextPowerOfTwo(uint64(counters))
+	if width < 16 {
+		width = 16
+	}
+	s := &cmSketch{mask: width - 1}
+	for i := range s.rows {
+		s.rows[i] = make([]uint64, width/16)
+	}
+	return s
+}
+
+// index 计算第row行中hash对应的计数器下标
+func (s *cmSketch) index(hash uint64, row int) uint64 {
+	h := (hash ^ sketchSeeds[row]) * 0x9e3779b97f4a7c15
+	h ^= h >> 32
+	return h & s.mask
+}
+
+// Increment 将hash对应的各行计数器加一 已达上限的计数器保持不变
+func (s *cmSketch) Increment(hash uint64) {
+	for row := range s.rows {
+		idx := s.index(hash, row)
+		word, shift := idx/16, (idx%16)*4
+		if (s.rows[row][word]>>shift)&counterMax < counterMax {
+			s.rows[row][word] += 1 << shift
+		}
+	}
+}
+
+// Estimate 返回hash对应各行计数器的最小值 即估算的访问频次
+func (s *cmSketch) Estimate(hash uint64) uint64 {
+	min := uint64(counterMax)
+	for row := range s.rows {
+		idx := s.index(hash, row)
+		word, shift := idx/16, (idx%16)*4
+		if v := (s.rows[row][word] >> shift) & counterMax; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// Reset 将所有计数器减半 使过去的热点随时间老化
+func (s *cmSketch) Reset() {
+	for _, row := range s.rows {
+		for i := range row {
+			// 每个4bit计数器右移一位 并屏蔽掉从高位计数器移入的bit
+			row[i] = (row[i] >> 1) & 0x7777777777777777
+		}
+	}
+}
+
+func nextPowerOfTwo(x uint64) uint64 {
+	n := uint64(1)
+	for n < x {
+		n <<= 1
+	}
+	return n
+}
@@ -0,0 +1,75 @@
+package tinylfu
+
+// tinylfu 包实现了TinyLFU准入过滤器
+// 由Count-Min Sketch统计访问频次 doorkeeper过滤一次性访问 并定期老化计数
+// 缓存写入新记录需要淘汰旧记录时 只有新记录的估算频次高于被淘汰者才允许写入
+// 从而避免长尾流量冲刷掉热点数据
+// Warning: tinylfu包不提供并发一致机制
+
+import "hash/fnv"
+
+// sampleFactor 每记录 sampleFactor*counters 次访问后老化一次
+const sampleFactor = 10
+
+// TinyLFU 是频次估算器与准入过滤器
+type TinyLFU struct {
+	sketch    *cmSketch
+	door      *doorkeeper
+	additions int // 自上次老化以来记录的访问次数
+	sample    int // 达到该次数后老化
+}
+
+// New 创建一个TinyLFU counters 应与缓存预计容纳的记录个数相当
+func New(counters int) *TinyLFU {
+	if counters < 1 {
+		counters = 1
+	}
+	sample := counters * sampleFactor
+	return &TinyLFU{
+		sketch: newCMSketch(counters),
+		// 两次老化之间doorkeeper最多插入sample个key
+		door:   newDoorkeeper(sample),
+		sample: sample,
+	}
+}
+
+// Increment 记录一次对key的访问
+func (t *TinyLFU) Increment(key string) {
+	hash := hashKey(key)
+	// 第一次出现的key只记入doorkeeper
+	if t.door.Add(hash) {
+		t.sketch.Increment(hash)
+	}
+	t.additions++
+	if t.additions >= t.sample {
+		t.reset()
+	}
+}
+
+// Estimate 估算key的访问频次
+func (t *TinyLFU) Estimate(key string) uint64 {
+	hash := hashKey(key)
+	freq := t.sketch.Estimate(hash)
+	if t.door.Has(hash) {
+		freq++
+	}
+	return freq
+}
+
+// Admit 判断候选者candidate能否淘汰victim 进入缓存
+func (t *TinyLFU) Admit(candidate, victim string) bool {
+	return t.Estimate(candidate) > t.Estimate(victim)
+}
+
+// reset 老化 计数器减半并清空doorkeeper
+func (t *TinyLFU) reset() {
+	t.additions = 0
+	t.sketch.Reset()
+	t.door.Reset()
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
@@ -0,0 +1,51 @@
+package tinylfu
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAdmit(t *testing.T) {
+	l := New(1024)
+	for i := 0; i < 10; i++ {
+		l.Increment("hot")
+	}
+	l.Increment("cold")
+	if !l.Admit("hot", "cold") || l.Admit("cold", "hot") {
+		t.Fatalf("hot=%d cold=%d", l.Estimate("hot"), l.Estimate("cold"))
+	}
+	if l.Estimate("unseen") != 0 {
+		t.Fatalf("unseen key estimated %d", l.Estimate("unseen"))
+	}
+}
+
+// TestDoorkeeperFalsePositive 两次老化之间插入的key不应使doorkeeper饱和
+func TestDoorkeeperFalsePositive(t *testing.T) {
+	l := New(1024)
+	for i := 0; i < l.sample-1; i++ {
+		l.Increment(fmt.Sprintf("seen%d", i))
+	}
+	positives := 0
+	for i := 0; i < 1000; i++ {
+		if l.Estimate(fmt.Sprintf("unseen%d", i)) > 0 {
+			positives++
+		}
+	}
+	if positives > 50 {
+		t.Fatalf("%d/1000 unseen keys have a nonzero estimate", positives)
+	}
+}
+
+func TestReset(t *testing.T) {
+	l := New(1)
+	for i := 0; i < 8; i++ {
+		l.Increment("key")
+	}
+	before := l.Estimate("key")
+	for i := 0; i < l.sample; i++ {
+		l.Increment(fmt.Sprintf("other%d", i))
+	}
+	if after := l.Estimate("key"); after >= before {
+		t.Fatalf("estimate %d did not age from %d", after, before)
+	}
+}
@@ -0,0 +1,137 @@
+package kcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// version 模块为每条缓存记录维护一个单调递增的版本
+// 版本由负责该key的节点在每次写入时分配 CompareAndSwap 与 GetWithVersion 都在该节点上执行
+// 同一key的写入经由分段锁串行 因此对同一key的读写是线性一致的
+
+// lockStripes 分段锁的段数 不同的key可能共用同一把锁
+const lockStripes = 256
+
+// ErrVersionMismatch CompareAndSwap时key的当前版本与期望的版本不同
+var ErrVersionMismatch = errors.New("kcache: version mismatch")
+
+// lockKey 返回key所在的分段锁
+func (g *Group) lockKey(key string) *sync.Mutex {
+	return &g.locks[fnv32(key)%lockStripes]
+}
+
+// nextVersion 分配一个新的版本
+// 版本不小于当前的纳秒时间戳 节点变化后新的负责节点分配的版本通常也大于旧节点分配过的版本
+func (g *Group) nextVersion() uint64 {
+	for {
+		old := g.versions.Load()
+		next := max(old+1, uint64(time.Now().UnixNano()))
+		if g.versions.CompareAndSwap(old, next) {
+			return next
+		}
+	}
+}
+
+// GetWithVersion 从负责该key的节点获取key对应的值及其版本
+// 不使用本节点hotcache中的副本 返回的版本可以交给 CompareAndSwap
+func (g *Group) GetWithVersion(key string) (ByteView, uint64, error) {
+	return g.GetWithVersionContext(context.Background(), key)
+}
+
+// GetWithVersionContext 与 GetWithVersion 相同 ctx的截止时间与取消会传递给远端节点与Retriever
+func (g *Group) GetWithVersionContext(ctx context.Context, key string) (ByteView, uint64, error) {
+	if key == "" {
+		return ByteView{}, 0, fmt.Errorf("key required")
+	}
+	if g.server != nil {
+		if fetcher, ok := g.server.Pick(key); ok {
+			r, err := fetcher.Fetch(ctx, g.name, key)
+			if err != nil {
+				g.stats.peerErrors.Add(1)
+				return ByteView{}, 0, err
+			}
+			g.stats.peerLoads.Add(1)
+			view := g.populateHotCache(key, r)
+			return view, view.version, nil
+		}
+	}
+
+	if view, ok := g.cache.get(key); ok {
+		return view, view.version, nil
+	}
+	view, err := g.loadLocally(ctx, key)
+	if err != nil {
+		return ByteView{}, 0, err
+	}
+	return view, view.version, nil
+}
+
+// CompareAndSwap 当key的当前版本等于expected时写入value 返回新的版本
+// expected为0代表期望key不在缓存中 版本不同时返回 ErrVersionMismatch 与当前的版本
+// 比较与写入在负责该key的节点上执行 成功后广播至其余节点 删除它们hotcache中的旧副本
+func (g *Group) CompareAndSwap(key string, expected uint64, value []byte, opts ...SetOption) (uint64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("key required")
+	}
+	o := setOptions{ttl: g.ttl}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ctx := context.Background()
+
+	if g.server == nil {
+		return g.compareAndSwapLocally(ctx, key, expected, value, o.ttl, o.tags)
+	}
+
+	owner, remote := g.server.Pick(key)
+	if !remote {
+		version, err := g.compareAndSwapLocally(ctx, key, expected, value, o.ttl, o.tags)
+		if err == nil {
+			g.invalidatePeers(key, nil)
+		}
+		return version, err
+	}
+
+	swapper, ok := owner.(Swapper)
+	if !ok {
+		return 0, fmt.Errorf("peer of *%s* does not support compare and swap", key)
+	}
+	version, err := swapper.CompareAndSwap(g.name, key, expected, value, o.ttl, o.tags...)
+	if err != nil {
+		return version, err
+	}
+	// 本节点不负责该key 删除本地可能残留的旧副本
+	g.removeLocally(key)
+	g.invalidatePeers(key, owner)
+	return version, nil
+}
+
+// compareAndSwapLocally 在本节点的cache上执行CompareAndSwap
+// 配置了Writer时 版本相同后先将值交给数据源 再写入cache
+func (g *Group) compareAndSwapLocally(ctx context.Context, key string, expected uint64, value []byte, ttl time.Duration, tags []string) (uint64, error) {
+	mu := g.lockKey(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	var current uint64
+	if view, ok := g.cache.lookup(key); ok {
+		current = view.version
+	} else if c, ok := g.counters.get(key); ok {
+		// 计数器在cache中的副本已被淘汰 恢复后才有版本
+		current = g.populateCounter(key, c).version
+	}
+	if current != expected {
+		return current, ErrVersionMismatch
+	}
+
+	if err := g.persist(ctx, key, value); err != nil {
+		return current, err
+	}
+	g.counters.remove(key)
+	g.hotcache.remove(key)
+	view := g.populateCache(key, ByteView{b: value, tags: tags}, ttl)
+	return view.version, nil
+}
@@ -0,0 +1,268 @@
+package kcache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// writer 模块使 Group.Set 写入的数据同时持久化至数据源
+// 数据源由负责该key的节点在key的锁内写入 与cache的写入顺序一致
+// write-through 在Set返回前同步写入数据源 写入失败时Set返回error且不修改缓存
+// write-behind 先修改缓存 再由后台协程定期将积攒的写入批量写入数据源
+// 同一key尚未写入的旧值会被新值覆盖 只写入最新的值
+
+const (
+	defaultFlushInterval = time.Second
+	defaultBatchSize     = 100
+	defaultWriteRetries  = 3
+	writeRetryBackoff    = 100 * time.Millisecond // 第一次重试前的等待时间 之后每次翻倍
+)
+
+// ErrWriterClosed Group已被关闭 write-behind不再接受写入
+var ErrWriterClosed = errors.New("kcache: write-behind closed")
+
+// Writer 将Set写入的数据持久化至数据源 是 Retriever 对应的写接口
+type Writer interface {
+	Write(ctx context.Context, key string, value []byte) error
+}
+
+// BatchWriter 是可选的接口 Writer同时实现它时
+// write-behind 将一批写入合并为一次WriteMulti 例如一条 INSERT ... ON DUPLICATE KEY UPDATE
+type BatchWriter interface {
+	Writer
+	WriteMulti(ctx context.Context, values map[string][]byte) error
+}
+
+// WriterFunc 将函数转换为 Writer
+type WriterFunc func(ctx context.Context, key string, value []byte) error
+
+func (f WriterFunc) Write(ctx context.Context, key string, value []byte) error {
+	return f(ctx, key, value)
+}
+
+// WithWriteThrough 令 Group.Set 先同步写入w 成功后再修改缓存
+func WithWriteThrough(w Writer) GroupOption {
+	return func(g *Group) {
+		g.writer = w
+		g.behind = nil
+	}
+}
+
+// WriteBehindOption 用于定制write-behind的行为
+type WriteBehindOption func(*writeBehind)
+
+// WithFlushInterval 设置写入数据源的周期 默认为1s d <= 0 时使用默认值
+func WithFlushInterval(d time.Duration) WriteBehindOption {
+	return func(wb *writeBehind) {
+		if d <= 0 {
+			d = defaultFlushInterval
+		}
+		wb.interval = d
+	}
+}
+
+// WithBatchSize 设置一批写入的最大个数 积攒的写入达到n个时立即写入 默认为100 n <= 0 时使用默认值
+func WithBatchSize(n int) WriteBehindOption {
+	return func(wb *writeBehind) {
+		if n <= 0 {
+			n = defaultBatchSize
+		}
+		wb.batchSize = n
+	}
+}
+
+// WithWriteRetries 设置一批写入失败后的重试次数 默认为3 为0时不重试 重试仍失败的写入将被丢弃并记录日志
+func WithWriteRetries(n int) WriteBehindOption {
+	return func(wb *writeBehind) {
+		wb.retries = max(n, 0)
+	}
+}
+
+// WithWriteBehind 令 Group.Set 先修改缓存 再由后台协程批量写入w
+// Group被关闭(DestroyGroup/Instance.Close)时 尚未写入的数据会全部写入后才返回
+func WithWriteBehind(w Writer, opts ...WriteBehindOption) GroupOption {
+	return func(g *Group) {
+		wb := &writeBehind{
+			w:         w,
+			interval:  defaultFlushInterval,
+			batchSize: defaultBatchSize,
+			retries:   defaultWriteRetries,
+			stats:     &g.stats,
+		}
+		for _, opt := range opts {
+			opt(wb)
+		}
+		g.writer = nil
+		g.behind = wb
+	}
+}
+
+// writeBehind 积攒Set的写入 由后台协程批量写入数据源
+type writeBehind struct {
+	w         Writer
+	interval  time.Duration
+	batchSize int
+	retries   int
+	stats     *groupStats
+
+	mu      sync.Mutex
+	pending map[string][]byte // 尚未写入的数据 同一key只保留最新的值 第一次写入时创建
+	order   []string          // pending中key的写入顺序
+	closed  bool              // close后不再接受写入
+
+	closeOnce sync.Once
+	kick      chan struct{} // 积攒的写入达到batchSize时通知后台协程
+	stop      chan struct{}
+	done      chan struct{} // 后台协程写完剩余数据退出后关闭
+}
+
+// init 启动后台协程 推迟到第一次写入时执行 调用时需持有wb.mu
+func (wb *writeBehind) init() {
+	if wb.pending != nil {
+		return
+	}
+	wb.pending = make(map[string][]byte)
+	wb.kick = make(chan struct{}, 1)
+	wb.stop = make(chan struct{})
+	wb.done = make(chan struct{})
+	go wb.run()
+}
+
+// enqueue 记录一次写入 value会被复制 close后返回 ErrWriterClosed
+func (wb *writeBehind) enqueue(key string, value []byte) error {
+	wb.mu.Lock()
+	if wb.closed {
+		wb.mu.Unlock()
+		return ErrWriterClosed
+	}
+	wb.init()
+	if _, ok := wb.pending[key]; !ok {
+		wb.order = append(wb.order, key)
+	}
+	wb.pending[key] = cloneBytes(value)
+	full := len(wb.pending) >= wb.batchSize
+	wb.mu.Unlock()
+
+	if full {
+		select {
+		case wb.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// run 定期或在积攒足够多的写入时写入数据源 收到stop后写完剩余数据退出
+func (wb *writeBehind) run() {
+	defer close(wb.done)
+
+	ticker := time.NewTicker(wb.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-wb.kick:
+		case <-wb.stop:
+			wb.flush()
+			return
+		}
+		wb.flush()
+	}
+}
+
+// flush 取出全部尚未写入的数据 按batchSize分批写入数据源
+func (wb *writeBehind) flush() {
+	wb.mu.Lock()
+	pending, order := wb.pending, wb.order
+	wb.pending = make(map[string][]byte)
+	wb.order = nil
+	wb.mu.Unlock()
+
+	for len(order) > 0 {
+		n := min(len(order), wb.batchSize)
+		batch := make(map[string][]byte, n)
+		for _, key := range order[:n] {
+			batch[key] = pending[key]
+		}
+		order = order[n:]
+		wb.writeBatch(batch)
+	}
+}
+
+// writeBatch 写入一批数据 失败时等待一段时间后重试
+func (wb *writeBehind) writeBatch(batch map[string][]byte) {
+	backoff := writeRetryBackoff
+	for attempt := 0; ; attempt++ {
+		failed := wb.write(batch)
+		if len(failed) == 0 {
+			return
+		}
+		if attempt >= wb.retries {
+			wb.stats.writeErrors.Add(int64(len(failed)))
+			log.Printf("fail to write %d keys to backing store after %d retries, drop them.\n", len(failed), wb.retries)
+			return
+		}
+		batch = failed
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// write 写入一批数据 返回写入失败的部分
+func (wb *writeBehind) write(batch map[string][]byte) map[string][]byte {
+	ctx := context.Background()
+	if bw, ok := wb.w.(BatchWriter); ok && len(batch) > 1 {
+		if err := bw.WriteMulti(ctx, batch); err != nil {
+			log.Printf("fail to write %d keys to backing store, %s.\n", len(batch), err.Error())
+			return batch
+		}
+		wb.stats.writes.Add(int64(len(batch)))
+		return nil
+	}
+
+	failed := make(map[string][]byte)
+	for key, value := range batch {
+		if err := wb.w.Write(ctx, key, value); err != nil {
+			log.Printf("fail to write *%s* to backing store, %s.\n", key, err.Error())
+			failed[key] = value
+			continue
+		}
+		wb.stats.writes.Add(1)
+	}
+	return failed
+}
+
+// close 不再接受写入 通知后台协程写完剩余数据 并等待其退出
+func (wb *writeBehind) close() {
+	wb.closeOnce.Do(func() {
+		wb.mu.Lock()
+		wb.closed = true
+		// 从未写入过时后台协程没有启动 无需等待
+		started := wb.pending != nil
+		wb.mu.Unlock()
+		if started {
+			close(wb.stop)
+			<-wb.done
+		}
+	})
+}
+
+// persist 在修改缓存前将Set写入的数据交给数据源
+// write-through 时同步写入并返回其结果 write-behind 时放入队列后立即返回
+func (g *Group) persist(ctx context.Context, key string, value []byte) error {
+	switch {
+	case g.writer != nil:
+		if err := g.writer.Write(ctx, key, value); err != nil {
+			g.stats.writeErrors.Add(1)
+			return err
+		}
+		g.stats.writes.Add(1)
+	case g.behind != nil:
+		return g.behind.enqueue(key, value)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package kcache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordWriter 记录每一批写入 前failures次写入返回错误
+type recordWriter struct {
+	mu       sync.Mutex
+	batches  []map[string]string
+	failures int
+}
+
+func (w *recordWriter) Write(ctx context.Context, key string, value []byte) error {
+	return w.WriteMulti(ctx, map[string][]byte{key: value})
+}
+
+func (w *recordWriter) WriteMulti(ctx context.Context, values map[string][]byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("store unavailable")
+	}
+	batch := make(map[string]string, len(values))
+	for key, value := range values {
+		batch[key] = string(value)
+	}
+	w.batches = append(w.batches, batch)
+	return nil
+}
+
+func (w *recordWriter) written() []map[string]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]map[string]string(nil), w.batches...)
+}
+
+func newWriterGroup(opts ...GroupOption) *Group {
+	return newGroup("writer", 0, RetrieverFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("not found")
+	}), opts...)
+}
+
+// TestWriteBehindBatch 积攒的写入达到batchSize时立即写入 同一key只写入最新的值
+func TestWriteBehindBatch(t *testing.T) {
+	w := &recordWriter{}
+	g := newWriterGroup(WithWriteBehind(w, WithBatchSize(3), WithFlushInterval(time.Hour)))
+	defer g.close()
+
+	g.Set("a", []byte("1"))
+	g.Set("a", []byte("2"))
+	g.Set("b", []byte("1"))
+	if len(w.written()) != 0 {
+		t.Fatalf("flushed before batchSize")
+	}
+	g.Set("c", []byte("1"))
+
+	deadline := time.Now().Add(time.Second)
+	for len(w.written()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	want := []map[string]string{{"a": "2", "b": "1", "c": "1"}}
+	if got := w.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("batches = %v, want %v", got, want)
+	}
+	// 缓存在写入数据源前就已修改
+	if view, err := g.Get("a"); err != nil || view.String() != "2" {
+		t.Fatalf("Get = %q, %v", view.String(), err)
+	}
+}
+
+// TestWriteBehindRetry 写入失败后重试 重试仍失败的写入被丢弃并计入统计
+func TestWriteBehindRetry(t *testing.T) {
+	w := &recordWriter{failures: 2}
+	g := newWriterGroup(WithWriteBehind(w, WithWriteRetries(2), WithFlushInterval(time.Hour)))
+	g.Set("a", []byte("1"))
+	g.close()
+	if got := w.written(); len(got) != 1 || got[0]["a"] != "1" {
+		t.Fatalf("batches = %v after 2 retries", got)
+	}
+
+	w = &recordWriter{failures: 2}
+	g = newWriterGroup(WithWriteBehind(w, WithWriteRetries(1), WithFlushInterval(time.Hour)))
+	g.Set("a", []byte("1"))
+	g.close()
+	if got := w.written(); len(got) != 0 {
+		t.Fatalf("batches = %v, want dropped", got)
+	}
+	if g.stats.writeErrors.Load() != 1 {
+		t.Fatalf("writeErrors = %d, want 1", g.stats.writeErrors.Load())
+	}
+}
+
+// TestWriteBehindClose 关闭时写完剩余数据 之后的Set返回 ErrWriterClosed
+func TestWriteBehindClose(t *testing.T) {
+	w := &recordWriter{}
+	g := newWriterGroup(WithWriteBehind(w, WithFlushInterval(time.Hour)))
+	for _, key := range []string{"a", "b", "c"} {
+		g.Set(key, []byte(key))
+	}
+	g.close()
+
+	want := []map[string]string{{"a": "a", "b": "b", "c": "c"}}
+	if got := w.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("batches = %v, want %v", got, want)
+	}
+	if err := g.Set("d", []byte("d")); !errors.Is(err, ErrWriterClosed) {
+		t.Fatalf("Set after close = %v, want ErrWriterClosed", err)
+	}
+
+	// 从未写入过的Group关闭后同样拒绝写入
+	g = newWriterGroup(WithWriteBehind(&recordWriter{}))
+	g.close()
+	if err := g.Set("a", []byte("a")); !errors.Is(err, ErrWriterClosed) {
+		t.Fatalf("Set after close = %v, want ErrWriterClosed", err)
+	}
+}
+
+// TestWriteThrough 写入数据源失败时Set返回error且不修改缓存
+func TestWriteThrough(t *testing.T) {
+	w := &recordWriter{failures: 1}
+	g := newWriterGroup(WithWriteThrough(w))
+	defer g.close()
+
+	if err := g.Set("a", []byte("1")); err == nil {
+		t.Fatalf("Set succeeded while the store failed")
+	}
+	if _, ok := g.cache.lookup("a"); ok {
+		t.Fatalf("cache modified after a failed write")
+	}
+	if err := g.Set("a", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := g.cache.lookup("a"); view.String() != "2" {
+		t.Fatalf("cache = %q, want 2", view.String())
+	}
+	if got := w.written(); len(got) != 1 || got[0]["a"] != "2" {
+		t.Fatalf("batches = %v", got)
+	}
+}
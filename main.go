@@ -22,8 +22,18 @@ func main() {
 		"Sam":  "567",
 	}
 
-	// 新建cache实例
-	group := kcache.NewGroup(addr, "scores", 2<<10, kcache.RetrieverFunc(
+	// 启动本节点的服务 返回时已注册至etcd并取得当前的节点列表
+	svr, err := kcache.NewServer(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := svr.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer svr.Stop()
+
+	// 新建cache实例 挂载至本节点的服务
+	group := kcache.NewGroup("scores", 2<<10, kcache.RetrieverFunc(
 		//如果所有主机缓存中没有，则从数据库中取
 		func(key string) ([]byte, error) {
 			log.Println("[Mysql] search key", key)
@@ -31,7 +41,7 @@ func main() {
 				return []byte(v), nil
 			}
 			return nil, fmt.Errorf("%s not exist", key)
-		}))
+		}), kcache.WithPicker(svr))
 
 	var key string
 	for key != "0" {